@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type CacheOptions struct {
+	// TTL is how long fetched versions are served without contacting the upstream.
+	TTL time.Duration
+	// StaleTTL is how long expired versions are still served while being refreshed in the background.
+	StaleTTL time.Duration
+	// NegativeTTL is how long an upstream error is remembered before the entry is fetched again.
+	NegativeTTL time.Duration
+}
+
+type cacheItem struct {
+	Versions  []Version
+	Err       string
+	FetchedAt time.Time
+}
+
+// CachedProvider caches the versions of every entry in a CacheBackend, which may be shared between replicas.
+type CachedProvider struct {
+	provider PackageProvider
+	backend  CacheBackend
+	options  CacheOptions
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+}
+
+func NewCachedProvider(provider PackageProvider, backend CacheBackend, options CacheOptions) *CachedProvider {
+	return &CachedProvider{
+		provider:   provider,
+		backend:    backend,
+		options:    options,
+		refreshing: map[string]bool{},
+	}
+}
+
+// cacheKey identifies an entry by its id and a digest of all its fields, so an edited entry never hits a stale item.
+func cacheKey(entry PackageListEntry) string {
+	return fmt.Sprintf("%s:%x", entry.Id, sha256.Sum256([]byte(fmt.Sprintf("%#v", entry))))
+}
+
+func versionsCacheKey(entry PackageListEntry) string {
+	return "versions:" + cacheKey(entry)
+}
+
+func (c *CachedProvider) load(ctx context.Context, key string) (cacheItem, bool) {
+	contents, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache read failed", "key", key, "error", err)
+		return cacheItem{}, false
+	}
+
+	if !ok {
+		return cacheItem{}, false
+	}
+
+	var item cacheItem
+	if err := json.Unmarshal(contents, &item); err != nil {
+		slog.Warn("cache item decode failed", "key", key, "error", err)
+		return cacheItem{}, false
+	}
+
+	return item, true
+}
+
+func (c *CachedProvider) store(ctx context.Context, key string, item cacheItem, ttl time.Duration) {
+	contents, err := json.Marshal(item)
+	if err != nil {
+		slog.Warn("cache item encode failed", "key", key, "error", err)
+		return
+	}
+
+	if err := c.backend.Set(ctx, key, contents, ttl); err != nil {
+		slog.Warn("cache write failed", "key", key, "error", err)
+	}
+}
+
+// FetchVersions implements PackageProvider.
+func (c *CachedProvider) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	key := versionsCacheKey(entry)
+
+	if item, ok := c.load(ctx, key); ok {
+		age := time.Since(item.FetchedAt)
+
+		switch {
+		case len(item.Err) != 0 && age < c.options.NegativeTTL:
+			return nil, errors.New(item.Err)
+		case len(item.Err) == 0 && age < c.options.TTL:
+			return item.Versions, nil
+		case len(item.Err) == 0 && age < c.options.TTL+c.options.StaleTTL:
+			c.mu.Lock()
+			if !c.refreshing[key] {
+				c.refreshing[key] = true
+				go c.refresh(context.WithoutCancel(ctx), key, entry)
+			}
+			c.mu.Unlock()
+			return item.Versions, nil
+		}
+	}
+
+	return c.fetch(ctx, key, entry)
+}
+
+func (c *CachedProvider) fetch(ctx context.Context, key string, entry PackageListEntry) ([]Version, error) {
+	versions, err := c.provider.FetchVersions(ctx, entry)
+	if err != nil {
+		// a canceled client request says nothing about the upstream, so it is never cached
+		if ctx.Err() == nil && c.options.NegativeTTL > 0 {
+			c.store(ctx, key, cacheItem{Err: err.Error(), FetchedAt: time.Now()}, c.options.NegativeTTL)
+		}
+		return nil, err
+	}
+
+	c.store(ctx, key, cacheItem{Versions: versions, FetchedAt: time.Now()}, c.options.TTL+c.options.StaleTTL)
+
+	return versions, nil
+}
+
+func (c *CachedProvider) refresh(ctx context.Context, key string, entry PackageListEntry) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.refreshing, key)
+		c.mu.Unlock()
+	}()
+
+	versions, err := c.provider.FetchVersions(ctx, entry)
+	if err != nil {
+		slog.Warn("background refresh failed, keep serving stale versions", "id", entry.Id, "error", err)
+		return
+	}
+
+	c.store(ctx, key, cacheItem{Versions: versions, FetchedAt: time.Now()}, c.options.TTL+c.options.StaleTTL)
+}
+
+// Invalidate forgets the cached versions of the entry.
+func (c *CachedProvider) Invalidate(entry PackageListEntry) {
+	if err := c.backend.Delete(context.Background(), versionsCacheKey(entry)); err != nil {
+		slog.Warn("cache delete failed", "id", entry.Id, "error", err)
+	}
+}
+
+// Retain implements entryRetainer. Cached versions are left to expire.
+func (c *CachedProvider) Retain(packageList []PackageListEntry) {
+	retainEntries(c.provider, packageList)
+}
+
+var _ PackageProvider = &CachedProvider{}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// cacheBackendCase is a backend under test, with how to let its items expire.
+type cacheBackendCase struct {
+	name    string
+	backend CacheBackend
+	elapse  func(d time.Duration)
+}
+
+func cacheBackends(t *testing.T) []cacheBackendCase {
+	file, err := NewFileCacheBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := miniredis.RunT(t)
+	redis, err := NewRedisCacheBackend("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { redis.client.Close() })
+
+	return []cacheBackendCase{
+		{name: "memory", backend: NewMemoryCacheBackend(), elapse: time.Sleep},
+		{name: "file", backend: file, elapse: time.Sleep},
+		{name: "redis", backend: redis, elapse: server.FastForward},
+	}
+}
+
+func TestCacheBackendsStoreValues(t *testing.T) {
+	ctx := context.Background()
+
+	for _, c := range cacheBackends(t) {
+		if _, ok, err := c.backend.Get(ctx, "missing"); ok || err != nil {
+			t.Errorf("%s: Get(missing) = %v, %v, want a miss", c.name, ok, err)
+		}
+
+		if err := c.backend.Set(ctx, "key", []byte("value"), time.Hour); err != nil {
+			t.Fatalf("%s: Set: %v", c.name, err)
+		}
+		if value, ok, err := c.backend.Get(ctx, "key"); !ok || err != nil || string(value) != "value" {
+			t.Errorf("%s: Get = %q, %v, %v, want value", c.name, value, ok, err)
+		}
+
+		if err := c.backend.Set(ctx, "key", []byte("replaced"), time.Hour); err != nil {
+			t.Fatalf("%s: Set: %v", c.name, err)
+		}
+		if value, _, _ := c.backend.Get(ctx, "key"); string(value) != "replaced" {
+			t.Errorf("%s: Get = %q after replacing, want replaced", c.name, value)
+		}
+
+		if err := c.backend.Delete(ctx, "key"); err != nil {
+			t.Errorf("%s: Delete: %v", c.name, err)
+		}
+		if _, ok, err := c.backend.Get(ctx, "key"); ok || err != nil {
+			t.Errorf("%s: Get after Delete = %v, %v, want a miss", c.name, ok, err)
+		}
+
+		if err := c.backend.Delete(ctx, "missing"); err != nil {
+			t.Errorf("%s: Delete(missing): %v", c.name, err)
+		}
+	}
+}
+
+func TestCacheBackendsExpireItems(t *testing.T) {
+	ctx := context.Background()
+
+	for _, c := range cacheBackends(t) {
+		if err := c.backend.Set(ctx, "short", []byte("value"), 20*time.Millisecond); err != nil {
+			t.Fatalf("%s: Set: %v", c.name, err)
+		}
+		if err := c.backend.Set(ctx, "long", []byte("value"), time.Hour); err != nil {
+			t.Fatalf("%s: Set: %v", c.name, err)
+		}
+
+		c.elapse(50 * time.Millisecond)
+
+		if _, ok, err := c.backend.Get(ctx, "short"); ok || err != nil {
+			t.Errorf("%s: Get(short) = %v, %v, want expired", c.name, ok, err)
+		}
+		if _, ok, err := c.backend.Get(ctx, "long"); !ok || err != nil {
+			t.Errorf("%s: Get(long) = %v, %v, want a hit", c.name, ok, err)
+		}
+	}
+}
+
+func TestFileCacheBackendReplacesItemsAtomically(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	backend, err := NewFileCacheBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := [][]byte{bytes.Repeat([]byte("a"), 1<<16), bytes.Repeat([]byte("b"), 1<<16)}
+
+	var wg sync.WaitGroup
+	for _, value := range values {
+		wg.Add(1)
+		go func(value []byte) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := backend.Set(ctx, "key", value, time.Hour); err != nil {
+					t.Errorf("Set: %v", err)
+				}
+			}
+		}(value)
+	}
+
+	// readers only ever see a complete item
+	for i := 0; i < 200; i++ {
+		value, ok, err := backend.Get(ctx, "key")
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if ok && !bytes.Equal(value, values[0]) && !bytes.Equal(value, values[1]) {
+			t.Fatalf("Get returned a partial item of %d bytes", len(value))
+		}
+	}
+	wg.Wait()
+
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files in the cache directory, want 1", len(entries))
+	}
+}
+
+func TestRedisCacheBackendPrefixesKeys(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+
+	backend, err := NewRedisCacheBackend("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.client.Close()
+
+	if err := backend.Set(ctx, "key", []byte("value"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != redisKeyPrefix+"key" {
+		t.Errorf("keys = %v, want [%skey]", keys, redisKeyPrefix)
+	}
+
+	// keys of other services sharing the database are left alone
+	server.Set("key", "foreign")
+	if value, _, _ := backend.Get(ctx, "key"); string(value) != "value" {
+		t.Errorf("Get = %q, want value", value)
+	}
+
+	if err := backend.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if !server.Exists("key") || server.Exists(redisKeyPrefix+"key") {
+		t.Errorf("Delete removed the wrong key, keys = %v", server.Keys())
+	}
+}
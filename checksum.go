@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var checksumFlights = newFlightGroup[map[string]string]()
+
+// checksumCacheTTL is how long parsed checksums files are kept in the cache backend; they rarely change once released.
+const checksumCacheTTL = 24 * time.Hour
+
+// fetchChecksums downloads a checksums file and maps file names to their SHA256.
+// Concurrent downloads of the same URL are coalesced into one request, checksums found in the cache
+// backend are not downloaded at all and an unchanged file is not downloaded again.
+func fetchChecksums(client *http.Client, validators *ValidatorCache, cache CacheBackend, entry PackageListEntry, req *http.Request) (map[string]string, error) {
+	return checksumFlights.Do(req.Context(), req.URL.String(), func(ctx context.Context) (map[string]string, error) {
+		cacheKey := "checksums:" + req.URL.String()
+		if checksums, ok := loadChecksums(ctx, cache, cacheKey); ok {
+			return checksums, nil
+		}
+
+		req = req.WithContext(ctx)
+		validators.Apply(req)
+
+		checkSumRes, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("checksum download: %w", err)
+		}
+		defer checkSumRes.Body.Close()
+
+		if checkSumRes.StatusCode == http.StatusNotModified {
+			if checksums, ok := validators.Lookup(req.URL.String()); ok {
+				return checksums.(map[string]string), nil
+			}
+		}
+
+		if checkSumRes.StatusCode != 200 {
+			return nil, upstreamStatusError("checksum download", checkSumRes)
+		}
+
+		checksums := map[string]string{}
+
+		scanner := bufio.NewScanner(checkSumRes.Body)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("checksum format error")
+			}
+
+			checksums[fields[1]] = fields[0]
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("checksum read: %w", err)
+		}
+
+		validators.Store(entry, req.URL.String(), checkSumRes.Header, checksums)
+		storeChecksums(ctx, cache, cacheKey, checksums)
+
+		return checksums, nil
+	})
+}
+
+func loadChecksums(ctx context.Context, cache CacheBackend, key string) (map[string]string, bool) {
+	if cache == nil {
+		return nil, false
+	}
+
+	contents, ok, err := cache.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache read failed", "key", key, "error", err)
+		return nil, false
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	checksums := map[string]string{}
+	if err := json.Unmarshal(contents, &checksums); err != nil {
+		slog.Warn("cache item decode failed", "key", key, "error", err)
+		return nil, false
+	}
+
+	return checksums, true
+}
+
+func storeChecksums(ctx context.Context, cache CacheBackend, key string, checksums map[string]string) {
+	if cache == nil {
+		return
+	}
+
+	contents, err := json.Marshal(checksums)
+	if err != nil {
+		return
+	}
+
+	if err := cache.Set(ctx, key, contents, checksumCacheTTL); err != nil {
+		slog.Warn("cache write failed", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v2"
+)
+
+type command struct {
+	usage string
+	// args is the number of positional arguments.
+	args  int
+	flags func(flags *flag.FlagSet)
+	run   func(config Config, args []string) int
+}
+
+func commands() map[string]command {
+	output := "json"
+	live := false
+	recipients := []string{}
+	outputFlag := func(flags *flag.FlagSet) {
+		flags.StringVar(&output, "output", output, "output format: json or yaml")
+	}
+
+	return map[string]command{
+		"serve": {
+			usage: "serve the winget REST source",
+			run:   serve,
+		},
+		"validate": {
+			usage: "lint the package list, and resolve every entry with -resolve",
+			flags: func(flags *flag.FlagSet) {
+				flags.BoolVar(&live, "resolve", live, "resolve every entry against its provider")
+			},
+			run: func(config Config, args []string) int {
+				return validate(config, live)
+			},
+		},
+		"resolve": {
+			usage: "resolve <id>: fetch the package from its provider and print its manifests",
+			args:  1,
+			flags: outputFlag,
+			run: func(config Config, args []string) int {
+				return resolve(config, args[0], output)
+			},
+		},
+		"export": {
+			usage: "fetch every package and print all manifests",
+			flags: outputFlag,
+			run: func(config Config, args []string) int {
+				return export(config, output)
+			},
+		},
+		"encrypt": {
+			usage: "read a secret from stdin and print it age encrypted for the package list",
+			flags: func(flags *flag.FlagSet) {
+				flags.Var((*stringsValue)(&recipients), "recipient", "comma separated age recipients to encrypt to")
+			},
+			run: func(config Config, args []string) int {
+				return encrypt(recipients)
+			},
+		},
+		"config print": {
+			usage: "print the effective config with secrets masked",
+			run:   printConfig,
+		},
+	}
+}
+
+func printUsage(w io.Writer, commands map[string]command) {
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: winget-src <command> [flags] [args]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-14s %s\n", name, commands[name].usage)
+	}
+}
+
+// runCommand runs the command named by the leading words of args, serve when there are none.
+func runCommand(args []string) int {
+	commands := commands()
+
+	name := "serve"
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+		if name == "config" && len(args) != 0 {
+			name, args = name+" "+args[0], args[1:]
+		}
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage(os.Stderr, commands)
+		return exitErr
+	}
+
+	// positional arguments may come before or after the flags
+	positional := []string{}
+	for len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	config, rest, err := LoadConfig(args, cmd.flags)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOk
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	positional = append(positional, rest...)
+	if len(positional) != cmd.args {
+		slog.Error("wrong number of arguments", "command", name, "usage", cmd.usage)
+		return exitErr
+	}
+
+	slog.SetDefault(config.Logger(os.Stderr))
+
+	return cmd.run(config, positional)
+}
+
+func checkOutput(format string) error {
+	switch format {
+	case "json", "yaml":
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+func encodeOutput(w io.Writer, format string, v any) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case "yaml":
+		// the models only name their fields for JSON, so YAML is converted from JSON to keep the same keys in order
+		contents, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		var doc any = &yaml.MapSlice{}
+		if bytes.HasPrefix(contents, []byte("[")) {
+			doc = &[]yaml.MapSlice{}
+		}
+
+		if err := yaml.Unmarshal(contents, doc); err != nil {
+			return err
+		}
+
+		return yaml.NewEncoder(w).Encode(doc)
+	default:
+		return checkOutput(format)
+	}
+}
+
+// printConfig writes the effective config as YAML with its secrets masked.
+func printConfig(config Config, args []string) int {
+	if err := yaml.NewEncoder(os.Stdout).Encode(config.Redacted()); err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	return exitOk
+}
+
+// validate prints every diagnostic of the package list and fails when any is an error. With live set each
+// entry is also resolved against its provider.
+func validate(config Config, live bool) int {
+	source, path, err := fetchPackageList(context.Background(), config)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+	defer source.Close()
+
+	f, diagnostics, err := LintPackageList(path, config.PackageListOverlays, source.Root())
+	if err != nil {
+		slog.Error("invalid package list", "error", err)
+		return exitErr
+	}
+
+	if live && diagnosticsError(diagnostics) != nil {
+		slog.Warn("entries are not resolved until the errors are fixed")
+	} else if live {
+		resolver, err := config.SecretResolver()
+		if err != nil {
+			slog.Error(err.Error())
+			return exitErr
+		}
+
+		if err := diagnosticsError(resolveSecrets(&f, resolver, source.Root())); err != nil {
+			slog.Error("secrets cannot be resolved", "error", err)
+			return exitErr
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		provider, err := newUpstreamProvider(config, NewMemoryCacheBackend(), f.providers)
+		if err != nil {
+			slog.Error(err.Error())
+			return exitErr
+		}
+
+		resolved := make([]Diagnostic, len(f.entries))
+
+		parallel(len(f.entries), config.Upstream.Concurrency, func(i int) {
+			versions, err := provider.FetchVersions(ctx, f.entries[i])
+			switch {
+			case err != nil:
+				resolved[i] = f.at(i, "provider", SeverityError, "resolve failed: %s", err)
+			case len(versions) == 0:
+				resolved[i] = f.at(i, "provider", SeverityWarning, "resolved no versions")
+			}
+		})
+
+		for _, d := range resolved {
+			if len(d.Message) != 0 {
+				diagnostics = append(diagnostics, d)
+			}
+		}
+
+		sortDiagnostics(diagnostics)
+	}
+
+	for _, d := range diagnostics {
+		fmt.Println(d)
+	}
+
+	if diagnosticsError(diagnostics) != nil {
+		return exitErr
+	}
+
+	fmt.Printf("%s: %d packages OK\n", redactSourceURL(config.PackageList), len(f.entries))
+
+	return exitOk
+}
+
+// encrypt prints the secret read from stdin encrypted to the recipients, to be pasted as a token.
+func encrypt(recipients []string) int {
+	if len(recipients) == 0 {
+		slog.Error("at least one -recipient is required")
+		return exitErr
+	}
+
+	parsed, err := age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	secret, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	encrypted, err := encryptSecret(strings.TrimSpace(string(secret)), parsed)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	fmt.Println(encrypted)
+
+	return exitOk
+}
+
+// fetchPackageList brings the package list of config to a local path, to be loaded before the source is
+// closed.
+func fetchPackageList(ctx context.Context, config Config) (PackageListSource, string, error) {
+	if config.PackageList == "" {
+		return nil, "", errors.New("package list is required, set package_list, PACKAGE_LIST or -package-list")
+	}
+
+	source, err := NewPackageListSource(config.PackageList, config.Timeouts.PackageList)
+	if err != nil {
+		return nil, "", err
+	}
+
+	path, err := source.Fetch(ctx)
+	if err != nil {
+		source.Close()
+		return nil, "", err
+	}
+
+	return source, path, nil
+}
+
+// newCommandRepository builds a repository resolving straight from the upstreams, so the commands never
+// read versions cached by a running server.
+func newCommandRepository(config Config) (WingetSrcRepository, *ManifestIndex, error) {
+	resolver, err := config.SecretResolver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source, path, err := fetchPackageList(context.Background(), config)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer source.Close()
+
+	packageList, err := LoadPackageList(path, config.PackageListOverlays, source.Root(), resolver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider, err := newUpstreamProvider(config, NewMemoryCacheBackend(), packageList.Providers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := NewManifestIndex()
+
+	return NewWingetSrcRepository(packageList.Packages, provider, index, config.Upstream.Concurrency), index, nil
+}
+
+func resolve(config Config, identifier string, output string) int {
+	if err := checkOutput(output); err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	repository, _, err := newCommandRepository(config)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	pkgManifests, err := repository.QueryPackageManifests(ctx, identifier)
+	if err != nil {
+		slog.Error("resolve failed", "id", identifier, "error", err)
+		return exitErr
+	}
+
+	if err := encodeOutput(os.Stdout, output, pkgManifests); err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	return exitOk
+}
+
+// export prints the manifests of every package that could be resolved and fails when any package failed.
+func export(config Config, output string) int {
+	if err := checkOutput(output); err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	repository, index, err := newCommandRepository(config)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	packageList := repository.PackageList()
+	errs := make([]error, len(packageList))
+
+	parallel(len(packageList), config.Upstream.Concurrency, func(i int) {
+		_, errs[i] = repository.Refresh(ctx, packageList[i])
+	})
+
+	code := exitOk
+	for i, err := range errs {
+		if err != nil {
+			slog.Error("resolve failed", "id", packageList[i].Id, "error", err)
+			code = exitErr
+		}
+	}
+
+	if err := encodeOutput(os.Stdout, output, index.All()); err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	return code
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+type flightCall[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+
+	// waiters counts the callers waiting for the result; the call is canceled when the last one leaves.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup deduplicates concurrent calls sharing the same key: the first caller runs the function
+// and every caller arriving while it is in flight receives the same result, including the error.
+type flightGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+func newFlightGroup[T any]() *flightGroup[T] {
+	return &flightGroup[T]{
+		calls: map[string]*flightCall[T]{},
+	}
+}
+
+// Do runs fn once per key at a time. fn gets a context detached from the first caller, so one client going
+// away does not fail the others; it is only canceled once every caller has stopped waiting for it.
+func (g *flightGroup[T]) Do(ctx context.Context, key string, fn func(context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+
+		go func() {
+			call.val, call.err = fn(callCtx)
+			cancel()
+
+			g.forget(key, call)
+
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// later callers start a new call instead of joining the canceled one
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// forget removes call from the calls in flight, unless a newer call of the key already replaced it.
+func (g *flightGroup[T]) forget(key string, call *flightCall[T]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+// CoalescingProvider shares one upstream fetch among identical concurrent FetchVersions calls.
+type CoalescingProvider struct {
+	provider PackageProvider
+	flights  *flightGroup[[]Version]
+}
+
+func NewCoalescingProvider(provider PackageProvider) CoalescingProvider {
+	return CoalescingProvider{
+		provider: provider,
+		flights:  newFlightGroup[[]Version](),
+	}
+}
+
+// FetchVersions implements PackageProvider.
+func (c CoalescingProvider) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	return c.flights.Do(ctx, cacheKey(entry), func(ctx context.Context) ([]Version, error) {
+		return c.provider.FetchVersions(ctx, entry)
+	})
+}
+
+// Retain implements entryRetainer.
+func (c CoalescingProvider) Retain(packageList []PackageListEntry) {
+	retainEntries(c.provider, packageList)
+}
+
+var _ PackageProvider = CoalescingProvider{}
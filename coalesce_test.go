@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupSharesConcurrentCalls(t *testing.T) {
+	g := newFlightGroup[int]()
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+				calls.Add(1)
+				<-release
+				return 42, nil
+			})
+		}(i)
+	}
+
+	// every caller joins before the call completes
+	if !waitUntil(func() bool { return waiters(g, "key") == len(results) }) {
+		t.Fatal("callers did not join the call")
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("fn ran %d times, want 1", calls.Load())
+	}
+	for i, result := range results {
+		if result != 42 {
+			t.Errorf("caller %d got %d, want 42", i, result)
+		}
+	}
+}
+
+func TestFlightGroupSharesErrors(t *testing.T) {
+	g := newFlightGroup[int]()
+	failure := errors.New("upstream failed")
+
+	if _, err := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 0, failure
+	}); !errors.Is(err, failure) {
+		t.Errorf("err = %v, want %v", err, failure)
+	}
+}
+
+func TestFlightGroupCancelsWhenTheLastCallerLeaves(t *testing.T) {
+	g := newFlightGroup[int]()
+
+	canceled := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		close(canceled)
+		return 0, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	for _, ctx := range []context.Context{first, second} {
+		wg.Add(1)
+		go func(ctx context.Context) {
+			defer wg.Done()
+			g.Do(ctx, "key", fn)
+		}(ctx)
+	}
+
+	if !waitUntil(func() bool { return waiters(g, "key") == 2 }) {
+		t.Fatal("callers did not join the call")
+	}
+
+	cancelFirst()
+	select {
+	case <-canceled:
+		t.Fatal("call canceled while a caller still waits for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("call not canceled after every caller left")
+	}
+
+	wg.Wait()
+
+	if waiters(g, "key") != 0 {
+		t.Error("abandoned call still in flight")
+	}
+}
+
+func waiters(g *flightGroup[int], key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		return call.waiters
+	}
+
+	return 0
+}
+
+func waitUntil(condition func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// releaseWorkers bounds how many releases of a single entry are resolved at the same time.
+const releaseWorkers = 8
+
+// parallel calls fn for every index in [0, n) on at most workers goroutines and waits for all of them.
+func parallel(n int, workers int, fn func(i int)) {
+	if workers <= 0 || workers > n {
+		workers = n
+	}
+
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
+
+// ConcurrencyLimiter bounds the number of in-flight upstream requests, both in total and per host.
+type ConcurrencyLimiter struct {
+	global  chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func NewConcurrencyLimiter(global int, perHost int) *ConcurrencyLimiter {
+	var sem chan struct{}
+	if global > 0 {
+		sem = make(chan struct{}, global)
+	}
+
+	return &ConcurrencyLimiter{
+		global:  sem,
+		perHost: perHost,
+		hosts:   map[string]chan struct{}{},
+	}
+}
+
+func (l *ConcurrencyLimiter) host(host string) chan struct{} {
+	if l.perHost <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.perHost)
+		l.hosts[host] = sem
+	}
+
+	return sem
+}
+
+// Acquire blocks until a slot for the host is free and returns the function releasing it.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	acquired := []chan struct{}{}
+	release := func() {
+		for _, sem := range acquired {
+			<-sem
+		}
+	}
+
+	for _, sem := range []chan struct{}{l.global, l.host(host)} {
+		if sem == nil {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+			acquired = append(acquired, sem)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+// LimitedTransport is a http.RoundTripper that holds a ConcurrencyLimiter slot until the response body is drained or closed.
+type LimitedTransport struct {
+	next    http.RoundTripper
+	limiter *ConcurrencyLimiter
+}
+
+func NewLimitedTransport(next http.RoundTripper, limiter *ConcurrencyLimiter) LimitedTransport {
+	return LimitedTransport{
+		next:    next,
+		limiter: limiter,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t LimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.limiter.Acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	res.Body = &releaseOnClose{ReadCloser: res.Body, release: release}
+
+	return res, nil
+}
+
+var _ http.RoundTripper = LimitedTransport{}
+
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.once.Do(r.release)
+	}
+
+	return n, err
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+
+	return err
+}
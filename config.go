@@ -0,0 +1,504 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the effective configuration. Every setting is resolved with the precedence
+// flags > env vars > config file > defaults.
+type Config struct {
+	Listen                  string   `yaml:"listen"`
+	PackageList             string   `yaml:"package_list"`
+	PackageListOverlays     []string `yaml:"package_list_overlays"`
+	SourceIdentifier        string   `yaml:"source_identifier"`
+	ServerSupportedVersions []string `yaml:"server_supported_versions"`
+	StrictSearch            bool     `yaml:"strict_search"`
+
+	Timeouts TimeoutConfig  `yaml:"timeouts"`
+	Upstream UpstreamConfig `yaml:"upstream"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Sync     SyncConfig     `yaml:"sync"`
+	Snapshot SnapshotConfig `yaml:"snapshot"`
+	Webhooks WebhookConfig  `yaml:"webhooks"`
+	Secrets  SecretsConfig  `yaml:"secrets"`
+	Log      LogConfig      `yaml:"log"`
+}
+
+type TimeoutConfig struct {
+	Github     time.Duration `yaml:"github"`
+	Gitlab     time.Duration `yaml:"gitlab"`
+	ReadHeader time.Duration `yaml:"read_header"`
+	Shutdown   time.Duration `yaml:"shutdown"`
+	// Request bounds the handling of a request; 0 disables it.
+	Request time.Duration `yaml:"request"`
+	// PackageList bounds the download or git fetch of a remote package list.
+	PackageList time.Duration `yaml:"package_list"`
+}
+
+type UpstreamConfig struct {
+	Concurrency      int           `yaml:"concurrency"`
+	HostConcurrency  int           `yaml:"host_concurrency"`
+	Retries          int           `yaml:"retries"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+	// GithubTokens are rotated for api.github.com entries without their own token, never sent to other hosts.
+	GithubTokens []string `yaml:"github_tokens"`
+}
+
+type CacheConfig struct {
+	// Backend is memory, file or redis.
+	Backend     string        `yaml:"backend"`
+	Dir         string        `yaml:"dir"`
+	RedisUrl    string        `yaml:"redis_url"`
+	TTL         time.Duration `yaml:"ttl"`
+	StaleTTL    time.Duration `yaml:"stale_ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+type SyncConfig struct {
+	Interval                time.Duration `yaml:"interval"`
+	Jitter                  float64       `yaml:"jitter"`
+	PackageListPollInterval time.Duration `yaml:"package_list_poll_interval"`
+	// RemotePackageListPollInterval replaces PackageListPollInterval for package lists fetched over http or git,
+	// which cost a request to the remote on every poll.
+	RemotePackageListPollInterval time.Duration `yaml:"remote_package_list_poll_interval"`
+}
+
+type SnapshotConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+type WebhookConfig struct {
+	GithubSecret string `yaml:"github_secret"`
+	GitlabToken  string `yaml:"gitlab_token"`
+}
+
+type SecretsConfig struct {
+	// AgeIdentityFile is the age key file decrypting the encrypted values of the package list.
+	AgeIdentityFile string `yaml:"age_identity_file"`
+	// RemoteEnv are the env vars a package list fetched over http or git may reference with env:NAME.
+	RemoteEnv []string `yaml:"remote_env"`
+}
+
+type LogConfig struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+	// Format is text or json.
+	Format string `yaml:"format"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:           ":8080",
+		SourceIdentifier: "api.winget-src",
+		ServerSupportedVersions: []string{
+			"1.4.0",
+			"1.5.0",
+		},
+		Timeouts: TimeoutConfig{
+			Github:      30 * time.Second,
+			Gitlab:      30 * time.Second,
+			ReadHeader:  30 * time.Second,
+			Shutdown:    5 * time.Second,
+			Request:     60 * time.Second,
+			PackageList: 30 * time.Second,
+		},
+		Upstream: UpstreamConfig{
+			Concurrency:      16,
+			HostConcurrency:  4,
+			Retries:          3,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Cache: CacheConfig{
+			Backend:     "memory",
+			TTL:         5 * time.Minute,
+			StaleTTL:    time.Hour,
+			NegativeTTL: 30 * time.Second,
+		},
+		Sync: SyncConfig{
+			Interval:                      10 * time.Minute,
+			Jitter:                        0.1,
+			PackageListPollInterval:       10 * time.Second,
+			RemotePackageListPollInterval: 5 * time.Minute,
+		},
+		Snapshot: SnapshotConfig{
+			Interval: 5 * time.Minute,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// LoadConfig resolves the configuration from the config file named by -config or CONFIG_FILE, the env vars
+// and the flags in args. extra, when not nil, binds flags of the command that are not part of the config.
+// It returns the arguments left after the flags.
+func LoadConfig(args []string, extra func(flags *flag.FlagSet)) (Config, []string, error) {
+	// flags are parsed up front to find the config file, then applied again on top of the file and env vars
+	parsed := flag.NewFlagSet("winget-src", flag.ContinueOnError)
+	path := parsed.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	defaults := DefaultConfig()
+	bindConfigFlags(parsed, &defaults)
+	if extra != nil {
+		extra(parsed)
+	}
+	if err := parsed.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	config := DefaultConfig()
+
+	if *path != "" {
+		contents, err := os.ReadFile(*path)
+		if err != nil {
+			return Config{}, nil, err
+		}
+
+		if err := yaml.UnmarshalStrict(contents, &config); err != nil {
+			return Config{}, nil, fmt.Errorf("%s: %w", *path, err)
+		}
+	}
+
+	if err := config.applyEnv(); err != nil {
+		return Config{}, nil, err
+	}
+
+	flags := flag.NewFlagSet("winget-src", flag.ContinueOnError)
+	bindConfigFlags(flags, &config)
+
+	var err error
+	parsed.Visit(func(f *flag.Flag) {
+		if flags.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = flags.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	redactor.Add(config.Upstream.GithubTokens...)
+	redactor.Add(config.Webhooks.GithubSecret, config.Webhooks.GitlabToken)
+	if u, err := url.Parse(config.Cache.RedisUrl); err == nil {
+		if password, ok := u.User.Password(); ok {
+			redactor.Add(password)
+		}
+	}
+	redactor.Add(sourceURLPassword(config.PackageList))
+
+	return config, parsed.Args(), config.validate()
+}
+
+func bindConfigFlags(flags *flag.FlagSet, config *Config) {
+	flags.StringVar(&config.Listen, "listen", config.Listen, "address to listen on")
+	flags.StringVar(&config.PackageList, "package-list", config.PackageList, "package list: a file, a directory, an http(s) URL or git+REPOSITORY#BRANCH:PATH")
+	flags.Var((*stringsValue)(&config.PackageListOverlays), "package-list-overlays", "comma separated files or directories overlaid on the package list in order")
+	flags.StringVar(&config.SourceIdentifier, "source-identifier", config.SourceIdentifier, "SourceIdentifier of /information")
+	flags.Var((*stringsValue)(&config.ServerSupportedVersions), "server-supported-versions", "comma separated ServerSupportedVersions of /information")
+	flags.BoolVar(&config.StrictSearch, "strict-search", config.StrictSearch, "fail a search when any package fails")
+	flags.DurationVar(&config.Timeouts.Github, "github-timeout", config.Timeouts.Github, "timeout of GitHub requests")
+	flags.DurationVar(&config.Timeouts.Gitlab, "gitlab-timeout", config.Timeouts.Gitlab, "timeout of GitLab requests")
+	flags.DurationVar(&config.Timeouts.ReadHeader, "read-header-timeout", config.Timeouts.ReadHeader, "timeout to read request headers")
+	flags.DurationVar(&config.Timeouts.Shutdown, "shutdown-timeout", config.Timeouts.Shutdown, "timeout of graceful shutdown")
+	flags.DurationVar(&config.Timeouts.Request, "request-timeout", config.Timeouts.Request, "timeout to handle a request, 0 disables it")
+	flags.DurationVar(&config.Timeouts.PackageList, "package-list-timeout", config.Timeouts.PackageList, "timeout to fetch a remote package list")
+	flags.StringVar(&config.Cache.Backend, "cache-backend", config.Cache.Backend, "cache backend: memory, file or redis")
+	flags.StringVar(&config.Cache.Dir, "cache-dir", config.Cache.Dir, "directory of the file cache backend")
+	flags.StringVar(&config.Cache.RedisUrl, "redis-url", config.Cache.RedisUrl, "URL of the redis cache backend")
+	flags.DurationVar(&config.Cache.TTL, "cache-ttl", config.Cache.TTL, "how long versions are served from the cache, 0 disables the cache")
+	flags.DurationVar(&config.Cache.StaleTTL, "cache-stale-ttl", config.Cache.StaleTTL, "how long expired versions are served while refreshed")
+	flags.DurationVar(&config.Cache.NegativeTTL, "cache-negative-ttl", config.Cache.NegativeTTL, "how long upstream errors are cached")
+	flags.StringVar(&config.Secrets.AgeIdentityFile, "age-identity-file", config.Secrets.AgeIdentityFile, "age key file decrypting encrypted values of the package list")
+	flags.Var((*stringsValue)(&config.Secrets.RemoteEnv), "remote-secret-env", "comma separated env vars a remote package list may reference")
+	flags.StringVar(&config.Log.Level, "log-level", config.Log.Level, "log level: debug, info, warn or error")
+	flags.StringVar(&config.Log.Format, "log-format", config.Log.Format, "log format: text or json")
+}
+
+// stringsValue is a comma separated flag.Value.
+type stringsValue []string
+
+func (s *stringsValue) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsValue) Set(value string) error {
+	*s = splitList(value)
+	return nil
+}
+
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); len(v) != 0 {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func (c *Config) applyEnv() error {
+	if port := os.Getenv("PORT"); port != "" {
+		c.Listen = ":" + port
+	}
+
+	c.Listen = stringEnv("LISTEN_ADDR", c.Listen)
+	c.PackageList = stringEnv("PACKAGE_LIST", c.PackageList)
+	if overlays := os.Getenv("PACKAGE_LIST_OVERLAYS"); overlays != "" {
+		c.PackageListOverlays = splitList(overlays)
+	}
+	c.SourceIdentifier = stringEnv("SOURCE_IDENTIFIER", c.SourceIdentifier)
+	if versions := os.Getenv("SERVER_SUPPORTED_VERSIONS"); versions != "" {
+		c.ServerSupportedVersions = splitList(versions)
+	}
+	if tokens := os.Getenv("GITHUB_TOKENS"); tokens != "" {
+		c.Upstream.GithubTokens = splitList(tokens)
+	}
+	c.Cache.Backend = stringEnv("CACHE_BACKEND", c.Cache.Backend)
+	c.Cache.Dir = stringEnv("CACHE_DIR", c.Cache.Dir)
+	c.Cache.RedisUrl = stringEnv("REDIS_URL", c.Cache.RedisUrl)
+	c.Snapshot.Path = stringEnv("SNAPSHOT_PATH", c.Snapshot.Path)
+	c.Webhooks.GithubSecret = stringEnv("GITHUB_WEBHOOK_SECRET", c.Webhooks.GithubSecret)
+	c.Webhooks.GitlabToken = stringEnv("GITLAB_WEBHOOK_TOKEN", c.Webhooks.GitlabToken)
+	// the key file of SOPS is used as a fallback so that lists already encrypted for it need no extra setup
+	c.Secrets.AgeIdentityFile = stringEnv("AGE_IDENTITY_FILE", stringEnv("SOPS_AGE_KEY_FILE", c.Secrets.AgeIdentityFile))
+	if env := os.Getenv("REMOTE_SECRET_ENV"); env != "" {
+		c.Secrets.RemoteEnv = splitList(env)
+	}
+	c.Log.Level = stringEnv("LOG_LEVEL", c.Log.Level)
+	c.Log.Format = stringEnv("LOG_FORMAT", c.Log.Format)
+
+	var err error
+	durations := []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"GITHUB_TIMEOUT", &c.Timeouts.Github},
+		{"GITLAB_TIMEOUT", &c.Timeouts.Gitlab},
+		{"READ_HEADER_TIMEOUT", &c.Timeouts.ReadHeader},
+		{"SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown},
+		{"REQUEST_TIMEOUT", &c.Timeouts.Request},
+		{"PACKAGE_LIST_TIMEOUT", &c.Timeouts.PackageList},
+		{"BREAKER_COOLDOWN", &c.Upstream.BreakerCooldown},
+		{"CACHE_TTL", &c.Cache.TTL},
+		{"CACHE_STALE_TTL", &c.Cache.StaleTTL},
+		{"CACHE_NEGATIVE_TTL", &c.Cache.NegativeTTL},
+		{"SYNC_INTERVAL", &c.Sync.Interval},
+		{"PACKAGE_LIST_POLL_INTERVAL", &c.Sync.PackageListPollInterval},
+		{"REMOTE_PACKAGE_LIST_POLL_INTERVAL", &c.Sync.RemotePackageListPollInterval},
+		{"SNAPSHOT_INTERVAL", &c.Snapshot.Interval},
+	}
+	for _, d := range durations {
+		if *d.value, err = durationEnv(d.name, *d.value); err != nil {
+			return err
+		}
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"UPSTREAM_CONCURRENCY", &c.Upstream.Concurrency},
+		{"UPSTREAM_HOST_CONCURRENCY", &c.Upstream.HostConcurrency},
+		{"UPSTREAM_RETRIES", &c.Upstream.Retries},
+		{"BREAKER_THRESHOLD", &c.Upstream.BreakerThreshold},
+	}
+	for _, i := range ints {
+		if *i.value, err = intEnv(i.name, *i.value); err != nil {
+			return err
+		}
+	}
+
+	if c.Sync.Jitter, err = floatEnv("SYNC_JITTER", c.Sync.Jitter); err != nil {
+		return err
+	}
+
+	if c.StrictSearch, err = boolEnv("STRICT_SEARCH", c.StrictSearch); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c Config) validate() error {
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown log format %q", c.Log.Format)
+	}
+
+	if _, err := c.logLevel(); err != nil {
+		return err
+	}
+
+	if c.Upstream.Concurrency <= 0 || c.Upstream.HostConcurrency <= 0 {
+		return errors.New("upstream concurrency must be positive")
+	}
+
+	if c.Timeouts.Request < 0 {
+		return errors.New("request timeout must not be negative")
+	}
+
+	if c.Sync.Interval <= 0 {
+		return errors.New("sync interval must be positive")
+	}
+
+	// a jitter of 1 or more could make entries due again as soon as they are refreshed
+	if c.Sync.Jitter < 0 || c.Sync.Jitter >= 1 {
+		return fmt.Errorf("sync jitter %g must be at least 0 and less than 1", c.Sync.Jitter)
+	}
+
+	if c.Sync.PackageListPollInterval <= 0 {
+		return errors.New("package list poll interval must be positive")
+	}
+
+	if c.Sync.RemotePackageListPollInterval <= 0 {
+		return errors.New("remote package list poll interval must be positive")
+	}
+
+	if c.Snapshot.Path != "" && c.Snapshot.Interval <= 0 {
+		return errors.New("snapshot interval must be positive")
+	}
+
+	return nil
+}
+
+func (c Config) logLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", c.Log.Level)
+	}
+
+	return level, nil
+}
+
+// Logger builds the logger configured by Log.
+func (c Config) Logger(w io.Writer) *slog.Logger {
+	level, _ := c.logLevel()
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(w, options)
+	if c.Log.Format == "json" {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	return slog.New(NewRedactingHandler(handler, redactor))
+}
+
+// SecretResolver builds the resolver of the secret references of the package list.
+func (c Config) SecretResolver() (*SecretResolver, error) {
+	resolver := &SecretResolver{RemoteEnv: c.Secrets.RemoteEnv}
+
+	if c.Secrets.AgeIdentityFile != "" {
+		identities, err := LoadAgeIdentities(c.Secrets.AgeIdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		resolver.Identities = identities
+	}
+
+	return resolver, nil
+}
+
+// Redacted returns a copy of the config with its secrets masked, for printing.
+func (c Config) Redacted() Config {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "REDACTED"
+	}
+
+	tokens := []string{}
+	for _, token := range c.Upstream.GithubTokens {
+		tokens = append(tokens, mask(token))
+	}
+	c.Upstream.GithubTokens = tokens
+	c.Webhooks.GithubSecret = mask(c.Webhooks.GithubSecret)
+	c.Webhooks.GitlabToken = mask(c.Webhooks.GitlabToken)
+	c.Cache.RedisUrl = mask(c.Cache.RedisUrl)
+	c.PackageList = redactSourceURL(c.PackageList)
+
+	return c
+}
+
+func stringEnv(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+func durationEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("env var %s: %w", name, err)
+	}
+
+	return d, nil
+}
+
+func intEnv(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("env var %s: %w", name, err)
+	}
+
+	return i, nil
+}
+
+func floatEnv(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("env var %s: %w", name, err)
+	}
+
+	return f, nil
+}
+
+func boolEnv(name string, defaultValue bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("env var %s: %w", name, err)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConfigValidateRejectsIntervals(t *testing.T) {
+	tests := map[string]func(c *Config){
+		"sync interval":                     func(c *Config) { c.Sync.Interval = 0 },
+		"negative jitter":                   func(c *Config) { c.Sync.Jitter = -0.1 },
+		"jitter of 1":                       func(c *Config) { c.Sync.Jitter = 1 },
+		"package list poll interval":        func(c *Config) { c.Sync.PackageListPollInterval = 0 },
+		"remote package list poll interval": func(c *Config) { c.Sync.RemotePackageListPollInterval = 0 },
+		"snapshot interval": func(c *Config) {
+			c.Snapshot.Path = "snapshot.json"
+			c.Snapshot.Interval = 0
+		},
+	}
+
+	if err := DefaultConfig().validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	for name, modify := range tests {
+		config := DefaultConfig()
+		modify(&config)
+
+		if err := config.validate(); err == nil {
+			t.Errorf("%s: validate accepted the config", name)
+		}
+	}
+}
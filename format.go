@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// The package list files may be written in any of these formats. JSON and TOML are converted to YAML nodes
+// so that every format is linted with the same positions and unknown-key checks.
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+	formatTOML = "toml"
+)
+
+var packageListExtensions = map[string]string{
+	".yaml": formatYAML,
+	".yml":  formatYAML,
+	".json": formatJSON,
+	".toml": formatTOML,
+}
+
+// tomlStatementPattern matches the first statement of a TOML document: a table header or a key/value pair.
+var tomlStatementPattern = regexp.MustCompile(`^(\[\[?\s*[\w."' -]+\]\]?|[\w."'-]+\s*=)`)
+
+// detectFormat returns the format of a package list file from its extension, or from its content when the
+// extension is not a known one.
+func detectFormat(path string, contents []byte) string {
+	if format, ok := packageListExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+
+	trimmed := bytes.TrimSpace(contents)
+	if len(trimmed) != 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return formatJSON
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tomlStatementPattern.MatchString(line) {
+			return formatTOML
+		}
+		break
+	}
+
+	return formatYAML
+}
+
+// decodeDocument parses a package list file into the node of its document, or nil when the file is empty.
+func decodeDocument(path string, contents []byte) (*yaml.Node, error) {
+	switch detectFormat(path, contents) {
+	case formatJSON:
+		return decodeJSONDocument(path, contents)
+	case formatTOML:
+		return decodeTOMLDocument(path, contents)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// an empty file is an empty list
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	return root.Content[0], nil
+}
+
+// position returns the line and column of the byte offset in contents, both starting at 1.
+func position(contents []byte, offset int) (int, int) {
+	lead := contents[:offset]
+	start := bytes.LastIndexByte(lead, '\n') + 1
+
+	return bytes.Count(lead, []byte{'\n'}) + 1, utf8.RuneCount(lead[start:]) + 1
+}
+
+func scalarNode(tag string, value string, line int, column int) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value, Line: line, Column: column}
+}
+
+// jsonDocument walks the tokens of a JSON document, keeping track of where each value starts.
+type jsonDocument struct {
+	contents []byte
+	decoder  *json.Decoder
+}
+
+func decodeJSONDocument(path string, contents []byte) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(contents)) == 0 {
+		return nil, nil
+	}
+
+	d := jsonDocument{contents: contents, decoder: json.NewDecoder(bytes.NewReader(contents))}
+	d.decoder.UseNumber()
+
+	node, err := d.value()
+	if err == nil {
+		offset := d.start()
+		if _, trailing := d.decoder.Token(); trailing != io.EOF {
+			err = d.errorf(offset, "unexpected data after the document")
+		}
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := position(contents, int(syntaxErr.Offset))
+		err = fmt.Errorf("line %d column %d: %s", line, column, syntaxErr)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return node, nil
+}
+
+// start returns the offset of the next token, after the whitespace and separators preceding it.
+func (d *jsonDocument) start() int {
+	offset := int(d.decoder.InputOffset())
+	for offset < len(d.contents) && strings.IndexByte(" \t\r\n,:", d.contents[offset]) >= 0 {
+		offset++
+	}
+
+	return offset
+}
+
+func (d *jsonDocument) errorf(offset int, format string, args ...any) error {
+	line, column := position(d.contents, offset)
+
+	return fmt.Errorf("line %d column %d: %s", line, column, fmt.Sprintf(format, args...))
+}
+
+func (d *jsonDocument) value() (*yaml.Node, error) {
+	offset := d.start()
+	line, column := position(d.contents, offset)
+
+	token, err := d.decoder.Token()
+	if err == io.EOF {
+		return nil, d.errorf(offset, "unexpected end of the document")
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line, Column: column}
+			for d.decoder.More() {
+				item, err := d.value()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, item)
+			}
+			_, err := d.decoder.Token()
+			return node, err
+		}
+
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line, Column: column}
+		keys := map[string]bool{}
+		for d.decoder.More() {
+			offset := d.start()
+			key, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			// strict like yaml.v3, which rejects duplicate keys too
+			if keys[key.Value] {
+				return nil, d.errorf(offset, "key %q already defined", key.Value)
+			}
+			keys[key.Value] = true
+
+			value, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, value)
+		}
+		_, err := d.decoder.Token()
+		return node, err
+	case string:
+		return scalarNode("!!str", token, line, column), nil
+	case json.Number:
+		if _, err := token.Int64(); err == nil {
+			return scalarNode("!!int", token.String(), line, column), nil
+		}
+		return scalarNode("!!float", token.String(), line, column), nil
+	case bool:
+		return scalarNode("!!bool", strconv.FormatBool(token), line, column), nil
+	default:
+		return scalarNode("!!null", "null", line, column), nil
+	}
+}
+
+// tomlDocument builds the nodes of a TOML document from the expressions of its parser. The document is
+// checked by the decoder first, so the builder only tracks where things are and never has to tell whether a
+// key or table may be defined again.
+type tomlDocument struct {
+	parser unstable.Parser
+	root   *yaml.Node
+}
+
+func decodeTOMLDocument(path string, contents []byte) (*yaml.Node, error) {
+	var decoded map[string]any
+	if err := toml.Unmarshal(contents, &decoded); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, locateTOMLError(contents, err))
+	}
+
+	d := &tomlDocument{root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1, Column: 1}}
+	d.parser.Reset(contents)
+
+	if err := d.build(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// an empty file is an empty list
+	if len(d.root.Content) == 0 {
+		return nil, nil
+	}
+
+	return d.root, nil
+}
+
+// locateTOMLError adds the position of the failing expression to a decoder error. Syntax errors come with it,
+// but redefined keys and tables do not: they are located at the last key of the first expression the
+// document fails to decode after.
+func locateTOMLError(contents []byte, err error) error {
+	message := strings.TrimPrefix(err.Error(), "toml: ")
+
+	var decodeErr *toml.DecodeError
+	if errors.As(err, &decodeErr) {
+		line, column := decodeErr.Position()
+		return fmt.Errorf("line %d column %d: %s", line, column, message)
+	}
+
+	var parser unstable.Parser
+	parser.Reset(contents)
+
+	// keys holds the last key of every expression, and starts the offset of the line it starts on
+	keys := []unstable.Range{}
+	starts := []int{}
+	for parser.NextExpression() {
+		var first, last *unstable.Node
+		for it := parser.Expression().Key(); it.Next(); {
+			if first == nil {
+				first = it.Node()
+			}
+			last = it.Node()
+		}
+
+		starts = append(starts, bytes.LastIndexByte(contents[:first.Raw.Offset], '\n')+1)
+		keys = append(keys, last.Raw)
+	}
+
+	for i, key := range keys {
+		end := len(contents)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+
+		var decoded map[string]any
+		if toml.Unmarshal(contents[:end], &decoded) != nil {
+			shape := parser.Shape(key)
+			return fmt.Errorf("line %d column %d: %s", shape.Start.Line, shape.Start.Column, message)
+		}
+	}
+
+	return errors.New(message)
+}
+
+func (d *tomlDocument) build() error {
+	// table is the table the key/value pairs belong to, changed by table headers
+	table := d.root
+
+	for d.parser.NextExpression() {
+		expression := d.parser.Expression()
+
+		switch expression.Kind {
+		case unstable.KeyValue:
+			if err := d.keyValue(table, expression); err != nil {
+				return err
+			}
+		case unstable.Table:
+			table = d.table(d.root, d.keys(expression.Key()))
+		case unstable.ArrayTable:
+			keys := d.keys(expression.Key())
+			table = d.arrayTable(d.table(d.root, keys[:len(keys)-1]), keys[len(keys)-1])
+		}
+	}
+
+	return d.parser.Error()
+}
+
+// at returns the position of the node, or fallback when the parser does not record one for it.
+func (d *tomlDocument) at(node *unstable.Node, fallback *yaml.Node) (int, int) {
+	if node.Raw.Length == 0 {
+		return fallback.Line, fallback.Column
+	}
+
+	shape := d.parser.Shape(node.Raw)
+
+	return shape.Start.Line, shape.Start.Column
+}
+
+// keys returns the parts of a dotted key as key nodes.
+func (d *tomlDocument) keys(it unstable.Iterator) []*yaml.Node {
+	keys := []*yaml.Node{}
+	for it.Next() {
+		line, column := d.at(it.Node(), d.root)
+		keys = append(keys, scalarNode("!!str", string(it.Node().Data), line, column))
+	}
+
+	return keys
+}
+
+func (d *tomlDocument) errorf(node *yaml.Node, format string, args ...any) error {
+	return fmt.Errorf("line %d column %d: %s", node.Line, node.Column, fmt.Sprintf(format, args...))
+}
+
+// table returns the table at the keys under parent, creating the missing ones. The last table of an array
+// of tables stands for the array.
+func (d *tomlDocument) table(parent *yaml.Node, keys []*yaml.Node) *yaml.Node {
+	for _, key := range keys {
+		value := valueAt(parent, key.Value)
+		switch {
+		case value == parent:
+			value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: key.Line, Column: key.Column}
+			parent.Content = append(parent.Content, key, value)
+		case value.Kind == yaml.SequenceNode:
+			value = value.Content[len(value.Content)-1]
+		}
+		parent = value
+	}
+
+	return parent
+}
+
+// arrayTable appends a table to the array of tables at key under parent.
+func (d *tomlDocument) arrayTable(parent *yaml.Node, key *yaml.Node) *yaml.Node {
+	array := valueAt(parent, key.Value)
+	if array == parent {
+		array = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: key.Line, Column: key.Column}
+		parent.Content = append(parent.Content, key, array)
+	}
+
+	table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: key.Line, Column: key.Column}
+	array.Content = append(array.Content, table)
+
+	return table
+}
+
+func (d *tomlDocument) keyValue(table *yaml.Node, expression *unstable.Node) error {
+	keys := d.keys(expression.Key())
+	key := keys[len(keys)-1]
+
+	value, err := d.value(expression.Value(), key)
+	if err != nil {
+		return err
+	}
+
+	parent := d.table(table, keys[:len(keys)-1])
+	parent.Content = append(parent.Content, key, value)
+
+	return nil
+}
+
+func (d *tomlDocument) value(node *unstable.Node, key *yaml.Node) (*yaml.Node, error) {
+	line, column := d.at(node, key)
+	data := string(node.Data)
+
+	switch node.Kind {
+	case unstable.Array:
+		array := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line, Column: column}
+		for it := node.Children(); it.Next(); {
+			item, err := d.value(it.Node(), key)
+			if err != nil {
+				return nil, err
+			}
+			array.Content = append(array.Content, item)
+		}
+		return array, nil
+	case unstable.InlineTable:
+		table := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line, Column: column}
+		for it := node.Children(); it.Next(); {
+			if err := d.keyValue(table, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+		return table, nil
+	case unstable.Integer:
+		// base prefixes and underscores are spelled the same in TOML and Go
+		i, err := strconv.ParseInt(data, 0, 64)
+		if err != nil {
+			return nil, d.errorf(scalarNode("", "", line, column), "invalid integer %s", data)
+		}
+		return scalarNode("!!int", strconv.FormatInt(i, 10), line, column), nil
+	case unstable.Float:
+		return d.float(data, line, column)
+	case unstable.Bool:
+		return scalarNode("!!bool", data, line, column), nil
+	default:
+		// strings, and dates which no field of the package list holds
+		return scalarNode("!!str", data, line, column), nil
+	}
+}
+
+func (d *tomlDocument) float(data string, line int, column int) (*yaml.Node, error) {
+	switch strings.TrimPrefix(strings.TrimPrefix(data, "+"), "-") {
+	case "inf":
+		if strings.HasPrefix(data, "-") {
+			return scalarNode("!!float", "-.inf", line, column), nil
+		}
+		return scalarNode("!!float", ".inf", line, column), nil
+	case "nan":
+		return scalarNode("!!float", ".nan", line, column), nil
+	}
+
+	f, err := strconv.ParseFloat(strings.ReplaceAll(data, "_", ""), 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, d.errorf(scalarNode("", "", line, column), "invalid float %s", data)
+	}
+
+	return scalarNode("!!float", strconv.FormatFloat(f, 'g', -1, 64), line, column), nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		path     string
+		contents string
+		want     string
+	}{
+		{"list.yaml", `{"packages": []}`, formatYAML},
+		{"list.YML", "", formatYAML},
+		{"list.json", "- id: A.B", formatJSON},
+		{"list.toml", "", formatTOML},
+		{"list", `{"packages": []}`, formatJSON},
+		{"list", `[{"id": "A.B"}]`, formatJSON},
+		{"list", "# comment\n\n[[packages]]\nid = \"A.B\"", formatTOML},
+		{"list", "[providers.gh]\ntype = \"github\"", formatTOML},
+		{"list", "include = [\"*.toml\"]", formatTOML},
+		{"list", "- id: A.B\n  name: b", formatYAML},
+		{"list", "packages:\n  - id: A.B", formatYAML},
+		{"list", "[A.B, C.D]", formatYAML},
+		{"list", "", formatYAML},
+	}
+
+	for _, test := range tests {
+		if got := detectFormat(test.path, []byte(test.contents)); got != test.want {
+			t.Errorf("detectFormat(%q, %q) = %s, want %s", test.path, test.contents, got, test.want)
+		}
+	}
+}
+
+func TestDecodeDocumentPositions(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		contents string
+		keys     []string
+		line     int
+		column   int
+	}{
+		{
+			name:     "json value",
+			path:     "list.json",
+			contents: "{\n  \"packages\": [\n    {\"id\": \"A.B\", \"name\": \"b\"}\n  ]\n}",
+			keys:     []string{"packages", "0", "name"},
+			line:     3,
+			column:   27,
+		},
+		{
+			name:     "json nested",
+			path:     "list.json",
+			contents: "[\n  {\n    \"id\": \"A.B\",\n    \"sync_interval\": \"1m\"\n  }\n]",
+			keys:     []string{"0", "sync_interval"},
+			line:     4,
+			column:   22,
+		},
+		{
+			name:     "json multibyte",
+			path:     "list.json",
+			contents: "[{\"name\": \"ツール\", \"id\": \"A.B\"}]",
+			keys:     []string{"0", "id"},
+			line:     1,
+			column:   24,
+		},
+		{
+			name:     "toml key value",
+			path:     "list.toml",
+			contents: "[[packages]]\nid = \"A.B\"\nname = \"b\"",
+			keys:     []string{"packages", "0", "name"},
+			line:     3,
+			column:   8,
+		},
+		{
+			name:     "toml table",
+			path:     "list.toml",
+			contents: "[providers.gh]\ntype = \"github\"\n\n[providers.gl]\ntype = \"gitlab\"",
+			keys:     []string{"providers", "gl", "type"},
+			line:     5,
+			column:   8,
+		},
+		{
+			name:     "toml table of an array of tables",
+			path:     "list.toml",
+			contents: "[[packages]]\nid = \"A.B\"\n[packages.http]\ntimeout = \"1m\"\n\n[[packages]]\nid = \"C.D\"",
+			keys:     []string{"packages", "0", "http", "timeout"},
+			line:     4,
+			column:   11,
+		},
+		{
+			name:     "toml table after dotted keys",
+			path:     "list.toml",
+			contents: "[providers.gh]\nhttp.timeout = \"5s\"\n[providers.gh.http.headers]\nX-Trace = \"1\"",
+			keys:     []string{"providers", "gh", "http", "headers", "X-Trace"},
+			line:     4,
+			column:   11,
+		},
+	}
+
+	for _, test := range tests {
+		document, err := decodeDocument(test.path, []byte(test.contents))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		node := lookupNode(document, test.keys...)
+		if node == nil {
+			t.Errorf("%s: %s not found", test.name, strings.Join(test.keys, "."))
+			continue
+		}
+
+		if node.Line != test.line || node.Column != test.column {
+			t.Errorf("%s: %s at %d:%d, want %d:%d", test.name, strings.Join(test.keys, "."), node.Line, node.Column, test.line, test.column)
+		}
+	}
+}
+
+func TestDecodeDocumentTOMLTables(t *testing.T) {
+	contents := `
+include = ["extra/*.toml"]
+
+[providers.gh]
+type = "github"
+http.timeout = "5s"
+
+[providers.gh.http.headers]
+X-Trace = "1"
+
+[providers]
+gl = { type = "gitlab" }
+
+[[packages]]
+id = "A.B"
+sync_interval = "1m"
+
+[packages.http]
+timeout = "1m"
+
+[[packages]]
+id = "C.D"
+installers.zip = true
+`
+
+	document, err := decodeDocument("list.toml", []byte(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]any
+	if err := document.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"include": []any{"extra/*.toml"},
+		"providers": map[string]any{
+			"gh": map[string]any{
+				"type": "github",
+				"http": map[string]any{"timeout": "5s", "headers": map[string]any{"X-Trace": "1"}},
+			},
+			"gl": map[string]any{"type": "gitlab"},
+		},
+		"packages": []any{
+			map[string]any{"id": "A.B", "sync_interval": "1m", "http": map[string]any{"timeout": "1m"}},
+			map[string]any{"id": "C.D", "installers": map[string]any{"zip": true}},
+		},
+	}
+
+	if got, expected := mustMarshal(t, decoded), mustMarshal(t, want); got != expected {
+		t.Errorf("decoded\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestDecodeDocumentRejectsInvalidDocuments(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		contents string
+		err      string
+	}{
+		{"json duplicate key", "list.json", "[{\"id\": \"A.B\",\n \"id\": \"C.D\"}]", `line 2 column 2: key "id" already defined`},
+		{"json trailing data", "list.json", "[] []", "line 1 column 4"},
+		{"json syntax", "list.json", "[{\"id\": }]", "list.json"},
+		{"toml duplicate key", "list.toml", "[[packages]]\nid = \"A.B\"\nid = \"C.D\"", "line 3 column 1: key id is already defined"},
+		{"toml duplicate key after a multiline value", "list.toml", "include = [\n  \"a.toml\",\n]\ninclude = []", "line 4 column 1: key include is already defined"},
+		{"toml duplicate table", "list.toml", "[providers.gh]\n[providers.gh]", "line 2 column 12: table gh already exists"},
+		{"toml implicit table", "list.toml", "[providers.gh]\n[providers]\n[providers]", "line 3 column 2: table providers already exists"},
+		{"toml table over array of tables", "list.toml", "[[packages]]\n[packages]", "line 2 column 2: "},
+		{"toml array of tables over table", "list.toml", "[packages]\n[[packages]]", "line 2 column 3: "},
+		{"toml array of tables over array", "list.toml", "packages = [{id = \"A.B\"}]\n[[packages]]", "line 2 column 3: "},
+		{"toml header over dotted keys", "list.toml", "[providers]\ngh.type = \"github\"\n[providers.gh]", "line 3 column 12: table gh already exists"},
+		{"toml dotted keys into header table", "list.toml", "[providers.gh]\ntype = \"github\"\n[providers]\ngh.token = \"x\"", "line 4 column 4: "},
+		{"toml dotted keys into inline table", "list.toml", "[providers]\ngh = { type = \"github\" }\ngh.token = \"x\"", "line 3 column 4: "},
+		{"toml header over inline table", "list.toml", "[providers]\ngh = { type = \"github\" }\n[providers.gh]", "line 3 column 12: "},
+		{"toml syntax", "list.toml", "[[packages]]\nid = ", "line 2"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeDocument(test.path, []byte(test.contents))
+		if err == nil {
+			t.Errorf("%s: decoded without error", test.name)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %q does not contain %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestLintPackageListReportsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		file     string
+		contents string
+		line     int
+		column   int
+	}{
+		{"list.yaml", "- provider: github\n  id: A.B\n  name: b\n  publisher: a\n  bogus: 1\n", 5, 3},
+		{"list.json", "[{\"provider\": \"github\", \"id\": \"A.B\", \"name\": \"b\", \"publisher\": \"a\",\n  \"bogus\": 1}]", 2, 3},
+		{"list.toml", "[[packages]]\nprovider = \"github\"\nid = \"A.B\"\nname = \"b\"\npublisher = \"a\"\nbogus = 1\n", 6, 1},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), test.file)
+		if err := os.WriteFile(path, []byte(test.contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, diagnostics, err := LintPackageList(path, nil, "")
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+
+		found := false
+		for _, diagnostic := range diagnostics {
+			if diagnostic.Message == `unknown key "bogus"` {
+				found = true
+				if diagnostic.Path != path || diagnostic.Line != test.line || diagnostic.Column != test.column {
+					t.Errorf("%s: unknown key at %s:%d:%d, want %s:%d:%d", test.file, diagnostic.Path, diagnostic.Line, diagnostic.Column, path, test.line, test.column)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: unknown key not reported in %v", test.file, diagnostics)
+		}
+	}
+}
+
+// lookupNode follows the mapping keys and sequence indexes from node, returning the node found or nil.
+func lookupNode(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		var next *yaml.Node
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == key {
+					next = node.Content[j+1]
+				}
+			}
+		case yaml.SequenceNode:
+			for j, item := range node.Content {
+				if key == strconv.Itoa(j) {
+					next = item
+				}
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+
+	return node
+}
+
+func mustMarshal(t *testing.T, v any) string {
+	t.Helper()
+
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out)
+}
@@ -1,19 +1,35 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Github struct {
+	Timeout time.Duration
+	Client  *http.Client
+	// Validators enables conditional requests for release pages and checksum files; optional.
+	Validators *ValidatorCache
+	// Cache shares parsed checksums files with other replicas; optional.
+	Cache CacheBackend
+	// Tokens rotates the tokens used for entries without their own and tracks rate limits; optional.
+	Tokens *GithubTokenPool
 }
 
+const (
+	githubDefaultEndpoint = "https://api.github.com"
+	// githubDefaultHost is the only host the tokens of upstream.github_tokens are sent to.
+	githubDefaultHost     = "api.github.com"
+	githubReleasesPerPage = 100
+)
+
 type githubAsset struct {
 	Name               string `json:"name"`
+	Url                string `json:"url"`
 	BrowserDownloadUrl string `json:"browser_download_url"`
 	ContentType        string `json:"content_type"`
 }
@@ -23,126 +39,310 @@ type githubRelease struct {
 	Assets []githubAsset `json:"assets"`
 }
 
+func (g Github) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+
+	return g.Client
+}
+
 // FetchVersions implements PackageProvider.
-func (g Github) FetchVersions(entry PackageListEntry) ([]Version, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://api.github.com/repos/%s/%s/releases", entry.Publisher, entry.Name), nil)
+func (g Github) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	releases, modified, err := g.fetchReleases(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	versionsKey := versionsCacheKey(entry)
+	if !modified {
+		if versions, ok := g.Validators.Lookup(versionsKey); ok {
+			return versions.([]Version), nil
+		}
+	}
+
+	var versions []Version
+
+	switch entry.InstallerType {
+	case "zip-portable":
+		versions, err = g.handleZipPortable(ctx, entry, releases)
+	default:
+		return nil, fmt.Errorf("unknown installer type: %s", entry.InstallerType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	g.Validators.Remember(entry, versionsKey, versions)
+
+	return versions, nil
+}
+
+// fetchReleases walks the Link header pagination of the releases API until the last page or entry.MaxReleases is reached.
+func (g Github) fetchReleases(ctx context.Context, entry PackageListEntry) ([]githubRelease, bool, error) {
+	owner, repo := g.repository(entry)
+
+	next := fmt.Sprintf("%s/repos/%s/%s/releases?per_page=%d", g.endpoint(entry), owner, repo, githubReleasesPerPage)
+	releases := []githubRelease{}
+	modified := false
+
+	for len(next) != 0 {
+		page, link, changed, err := g.fetchReleasesPage(ctx, entry, next)
+		if err != nil {
+			return nil, false, err
+		}
+
+		modified = modified || changed
+		releases = append(releases, page...)
+
+		if entry.MaxReleases > 0 && len(releases) >= entry.MaxReleases {
+			return releases[:entry.MaxReleases], modified, nil
+		}
+
+		next = link
+	}
+
+	return releases, modified, nil
+}
+
+type githubReleasesPage struct {
+	releases []githubRelease
+	next     string
+}
+
+// fetchReleasesPage returns the releases of a page, the next page and whether the page changed since it was last fetched.
+func (g Github) fetchReleasesPage(ctx context.Context, entry PackageListEntry, url string) ([]githubRelease, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("github releases API: %w", err)
+		return nil, "", false, fmt.Errorf("github releases API: %w", err)
 	}
 
-	if len(entry.Token) != 0 {
-		req.Header.Add("Authorization", fmt.Sprintf("token %s", entry.Token))
+	token, err := g.authorize(entry, req)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("github releases API: %w", err)
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	g.Validators.Apply(req)
+
+	res, err := g.client().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("github releases API: %w", err)
+		return nil, "", false, fmt.Errorf("github releases API: %w", err)
 	}
 	defer res.Body.Close()
 
+	g.Tokens.Observe(req.URL.Host, token, res.Header)
+
+	if res.StatusCode == http.StatusNotModified {
+		if page, ok := g.Validators.Lookup(url); ok {
+			return page.(githubReleasesPage).releases, page.(githubReleasesPage).next, false, nil
+		}
+	}
+
 	if res.StatusCode != 200 {
-		contents, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("github releases API status %d: %s", res.StatusCode, contents)
+		return nil, "", false, upstreamStatusError("github releases API", res)
 	}
 
 	releases := []githubRelease{}
 	if err := json.NewDecoder(res.Body).Decode(&releases); err != nil {
-		return nil, fmt.Errorf("github releases API response decode: %w", err)
+		return nil, "", false, fmt.Errorf("github releases API response decode: %w", err)
 	}
 
-	switch entry.InstallerType {
-	case "zip-portable":
-		return g.handleZipPortable(entry, releases)
-	default:
-		return nil, fmt.Errorf("unknown installer type: %s", entry.InstallerType)
+	next := nextLink(res.Header.Get("Link"))
+	g.Validators.Store(entry, url, res.Header, githubReleasesPage{releases: releases, next: next})
+
+	return releases, next, true, nil
+}
+
+// nextLink extracts the rel="next" target of an RFC 8288 Link header.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			}
+		}
+	}
+
+	return ""
+}
+
+// endpoint returns the REST API base URL, e.g. "https://ghe.example.com/api/v3" for GitHub Enterprise Server.
+func (g Github) endpoint(entry PackageListEntry) string {
+	if len(entry.Endpoint) == 0 {
+		return githubDefaultEndpoint
 	}
 
+	return strings.TrimSuffix(entry.Endpoint, "/")
 }
 
-func (g Github) handleZipPortable(entry PackageListEntry, releases []githubRelease) ([]Version, error) {
+// repository returns the owner and repository name, falling back to the display publisher and name.
+func (g Github) repository(entry PackageListEntry) (string, string) {
+	owner := entry.Owner
+	if len(owner) == 0 {
+		owner = entry.Publisher
+	}
+
+	repo := entry.Repo
+	if len(repo) == 0 {
+		repo = entry.Name
+	}
+
+	return owner, repo
+}
+
+// authorize sets the token picked for the entry on req and returns it.
+func (g Github) authorize(entry PackageListEntry, req *http.Request) (string, error) {
+	token, err := g.Tokens.Pick(req.URL.Host, entry.Token)
+	if err != nil {
+		return "", err
+	}
+
+	if len(token) != 0 {
+		req.Header.Add("Authorization", fmt.Sprintf("token %s", token))
+	}
+
+	return token, nil
+}
+
+// assetRequest downloads an asset. Entries with their own token or endpoint go through the API of the configured
+// host so that private and GHES assets are reachable with the entry token; public github.com assets are
+// downloaded from browser_download_url, which does not count against the API rate limit.
+func (g Github) assetRequest(ctx context.Context, entry PackageListEntry, asset githubAsset) (*http.Request, error) {
+	api := len(entry.Token) != 0 || g.endpoint(entry) != githubDefaultEndpoint || len(asset.BrowserDownloadUrl) == 0
+
+	url := asset.BrowserDownloadUrl
+	if api {
+		url = asset.Url
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !api {
+		return req, nil
+	}
+
+	req.Header.Add("Accept", "application/octet-stream")
+	if _, err := g.authorize(entry, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func (g Github) handleZipPortable(ctx context.Context, entry PackageListEntry, releases []githubRelease) ([]Version, error) {
+	found := make([]*Version, len(releases))
+	errs := make([]error, len(releases))
+
+	parallel(len(releases), releaseWorkers, func(i int) {
+		found[i], errs[i] = g.zipPortableVersion(ctx, entry, releases[i])
+	})
+
 	versions := []Version{}
 
-	for _, release := range releases {
-		installers := []Installer{}
-
-		checksums := map[string]string{}
-
-		for _, asset := range release.Assets {
-			lname := strings.ToLower(asset.Name)
-			if strings.Contains(lname, "checksum") && strings.Contains(asset.ContentType, "text/plain") {
-				checkSumRes, err := http.Get(asset.BrowserDownloadUrl)
-				if err != nil {
-					return nil, fmt.Errorf("checksum download: %w", err)
-				}
-				defer checkSumRes.Body.Close()
-
-				if checkSumRes.StatusCode != 200 {
-					contents, _ := io.ReadAll(checkSumRes.Body)
-					return nil, fmt.Errorf("checksum download status %d: %s", checkSumRes.StatusCode, contents)
-				}
-
-				scanner := bufio.NewScanner(checkSumRes.Body)
-				for scanner.Scan() {
-					line := scanner.Text()
-					if err := scanner.Err(); err != nil {
-						return nil, fmt.Errorf("checksum read: %w", err)
-					}
-
-					fields := strings.Fields(line)
-					if len(fields) != 2 {
-						return nil, fmt.Errorf("checksum format error")
-					}
-
-					checksums[fields[1]] = fields[0]
-				}
-			}
+	for i := range releases {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		if found[i] == nil {
+			continue
+		}
+
+		versions = append(versions, *found[i])
+	}
+
+	return versions, nil
+}
 
-			if !(strings.Contains(lname, "windows") && strings.HasSuffix(lname, ".zip")) {
-				continue
+// zipPortableVersion builds the version of a single release, or nil if the release has no Windows zip asset.
+func (g Github) zipPortableVersion(ctx context.Context, entry PackageListEntry, release githubRelease) (*Version, error) {
+	_, repo := g.repository(entry)
+
+	installers := []Installer{}
+
+	checksums := map[string]string{}
+
+	for _, asset := range release.Assets {
+		lname := strings.ToLower(asset.Name)
+		if strings.Contains(lname, "checksum") && strings.Contains(asset.ContentType, "text/plain") {
+			req, err := g.assetRequest(ctx, entry, asset)
+			if err != nil {
+				return nil, fmt.Errorf("checksum download: %w", err)
 			}
 
-			var arch string
-			if strings.Contains(lname, "x86_64") || strings.Contains(lname, "x64") {
-				arch = "x64"
-			} else if strings.Contains(lname, "i386") || strings.Contains(lname, "x86") {
-				arch = "x86"
-			} else if strings.Contains(lname, "arm64") {
-				arch = "arm64"
-			} else {
-				continue
+			found, err := fetchChecksums(g.client(), g.Validators, g.Cache, entry, req)
+			if err != nil {
+				return nil, err
 			}
 
-			checksum, ok := checksums[asset.Name]
-			if !ok {
-				checksum = ""
+			for name, checksum := range found {
+				checksums[name] = checksum
 			}
+		}
 
-			installers = append(installers, Installer{
-				Architecture:        arch,
-				InstallerType:       "zip",
-				InstallerUrl:        asset.BrowserDownloadUrl,
-				InstallerSha256:     checksum,
-				Scope:               "user",
-				NestedInstallerType: "portable",
-				NestedInstallerFiles: []NestedInstallerFile{
-					{
-						RelativeFilePath: fmt.Sprintf("%s.exe", entry.Name),
-					},
-				},
-			})
+		if !(strings.Contains(lname, "windows") && strings.HasSuffix(lname, ".zip")) {
+			continue
 		}
 
-		if len(installers) == 0 {
+		var arch string
+		if strings.Contains(lname, "x86_64") || strings.Contains(lname, "x64") {
+			arch = "x64"
+		} else if strings.Contains(lname, "i386") || strings.Contains(lname, "x86") {
+			arch = "x86"
+		} else if strings.Contains(lname, "arm64") {
+			arch = "arm64"
+		} else {
 			continue
 		}
 
-		versions = append(versions, Version{
-			Version:    release.Name,
-			Installers: installers,
+		checksum, ok := checksums[asset.Name]
+		if !ok {
+			checksum = ""
+		}
+
+		installers = append(installers, Installer{
+			Architecture:        arch,
+			InstallerType:       "zip",
+			InstallerUrl:        asset.BrowserDownloadUrl,
+			InstallerSha256:     checksum,
+			Scope:               "user",
+			NestedInstallerType: "portable",
+			NestedInstallerFiles: []NestedInstallerFile{
+				{
+					RelativeFilePath: fmt.Sprintf("%s.exe", repo),
+				},
+			},
 		})
 	}
 
-	return versions, nil
+	if len(installers) == 0 {
+		return nil, nil
+	}
+
+	return &Version{
+		Version:    release.Name,
+		Installers: installers,
+	}, nil
+}
+
+// Retain implements entryRetainer.
+func (g Github) Retain(packageList []PackageListEntry) {
+	g.Validators.Retain(packageList)
 }
 
 var _ PackageProvider = Github{}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGithubAssetRequest(t *testing.T) {
+	asset := githubAsset{
+		Url:                "https://api.github.com/repos/acme/tool/releases/assets/1",
+		BrowserDownloadUrl: "https://github.com/acme/tool/releases/download/v1.0.0/checksums.txt",
+	}
+
+	enterprise := githubAsset{
+		Url:                "https://ghe.example.com/api/v3/repos/acme/tool/releases/assets/1",
+		BrowserDownloadUrl: "https://ghe.example.com/acme/tool/releases/download/v1.0.0/checksums.txt",
+	}
+
+	tests := []struct {
+		name   string
+		entry  PackageListEntry
+		asset  githubAsset
+		url    string
+		accept string
+		auth   string
+	}{
+		{"public", PackageListEntry{}, asset, asset.BrowserDownloadUrl, "", ""},
+		{"entry token", PackageListEntry{Token: "secret"}, asset, asset.Url, "application/octet-stream", "token secret"},
+		{"enterprise endpoint", PackageListEntry{Endpoint: "https://ghe.example.com/api/v3"}, enterprise, enterprise.Url, "application/octet-stream", ""},
+	}
+
+	g := Github{Tokens: NewGithubTokenPool(githubDefaultHost, []string{"pooled"})}
+
+	for _, test := range tests {
+		req, err := g.assetRequest(context.Background(), test.entry, test.asset)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if req.URL.String() != test.url || req.Header.Get("Accept") != test.accept || req.Header.Get("Authorization") != test.auth {
+			t.Errorf("%s: GET %s with Accept %q and Authorization %q, want %s with %q and %q", test.name, req.URL, req.Header.Get("Accept"), req.Header.Get("Authorization"), test.url, test.accept, test.auth)
+		}
+	}
+}
+
+func TestGithubRetainDropsValidatorsOfUnlistedEntries(t *testing.T) {
+	listed := PackageListEntry{Id: "Listed.Package"}
+	removed := PackageListEntry{Id: "Removed.Package"}
+
+	header := http.Header{"Etag": []string{`"1"`}}
+
+	g := Github{Validators: NewValidatorCache()}
+	g.Validators.Store(listed, "https://api.github.com/repos/acme/listed/releases", header, githubReleasesPage{})
+	g.Validators.Remember(listed, versionsCacheKey(listed), []Version{})
+	g.Validators.Store(removed, "https://api.github.com/repos/acme/removed/releases", header, githubReleasesPage{})
+	g.Validators.Remember(removed, versionsCacheKey(removed), []Version{})
+
+	g.Retain([]PackageListEntry{listed})
+
+	for _, key := range []string{"https://api.github.com/repos/acme/listed/releases", versionsCacheKey(listed)} {
+		if _, ok := g.Validators.Lookup(key); !ok {
+			t.Errorf("%s of the listed entry was dropped", key)
+		}
+	}
+	for _, key := range []string{"https://api.github.com/repos/acme/removed/releases", versionsCacheKey(removed)} {
+		if _, ok := g.Validators.Lookup(key); ok {
+			t.Errorf("%s of the removed entry was kept", key)
+		}
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{`<https://api.github.com/repositories/1/releases?page=2>; rel="next", <https://api.github.com/repositories/1/releases?page=5>; rel="last"`, "https://api.github.com/repositories/1/releases?page=2"},
+		{`<https://api.github.com/repositories/1/releases?page=1>; rel="prev", <https://api.github.com/repositories/1/releases?page=3>; rel="next"`, "https://api.github.com/repositories/1/releases?page=3"},
+		{`<https://api.github.com/repositories/1/releases?page=1>; rel="first"`, ""},
+		{`<https://api.github.com/repositories/1/releases?page=2>`, ""},
+	}
+
+	for _, test := range tests {
+		if got := nextLink(test.header); got != test.want {
+			t.Errorf("nextLink(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestGithubFetchReleasesFollowsPages(t *testing.T) {
+	pages := map[string]struct {
+		body string
+		link string
+	}{
+		"https://api.github.com/repos/acme/tool/releases?per_page=100":       {`[{"name": "v3"}, {"name": "v2"}]`, `<https://api.github.com/repositories/1/releases?per_page=100&page=2>; rel="next"`},
+		"https://api.github.com/repositories/1/releases?per_page=100&page=2": {`[{"name": "v1"}]`, ""},
+	}
+
+	requested := []string{}
+	g := Github{Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		page := pages[req.URL.String()]
+		header := http.Header{}
+		if len(page.link) != 0 {
+			header.Set("Link", page.link)
+		}
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader(page.body)), Request: req}, nil
+	})}}
+
+	tests := []struct {
+		maxReleases int
+		releases    string
+		requests    int
+	}{
+		{0, "v3 v2 v1", 2},
+		{3, "v3 v2 v1", 2},
+		// the last page is not requested once enough releases are found
+		{2, "v3 v2", 1},
+		{1, "v3", 1},
+	}
+
+	for _, test := range tests {
+		requested = nil
+
+		releases, _, err := g.fetchReleases(context.Background(), PackageListEntry{Owner: "acme", Repo: "tool", MaxReleases: test.maxReleases})
+		if err != nil {
+			t.Fatalf("max_releases %d: %v", test.maxReleases, err)
+		}
+
+		names := []string{}
+		for _, release := range releases {
+			names = append(names, release.Name)
+		}
+
+		if strings.Join(names, " ") != test.releases || len(requested) != test.requests {
+			t.Errorf("max_releases %d: releases %v in %d requests, want %s in %d", test.maxReleases, names, len(requested), test.releases, test.requests)
+		}
+	}
+}
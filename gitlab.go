@@ -1,17 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Gitlab struct {
+	Timeout time.Duration
+	Client  *http.Client
+	// Validators enables conditional requests for release pages and checksum files; optional.
+	Validators *ValidatorCache
+	// Cache shares parsed checksums files with other replicas; optional.
+	Cache CacheBackend
 }
 
+const gitlabReleasesPerPage = 100
+
 type gitlabAssetLink struct {
 	Name     string `json:"name"`
 	Url      string `json:"url"`
@@ -27,126 +35,220 @@ type gitlabRelease struct {
 	Assets gitlabAssets `json:"assets"`
 }
 
+func (g Gitlab) client() *http.Client {
+	if g.Client == nil {
+		return http.DefaultClient
+	}
+
+	return g.Client
+}
+
 // FetchVersions implements PackageProvider.
-func (g Gitlab) FetchVersions(entry PackageListEntry) ([]Version, error) {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v4/projects/%d/releases", entry.Endpoint, entry.ProjectID), nil)
+func (g Gitlab) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	if g.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Timeout)
+		defer cancel()
+	}
+
+	releases, modified, err := g.fetchReleases(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	versionsKey := versionsCacheKey(entry)
+	if !modified {
+		if versions, ok := g.Validators.Lookup(versionsKey); ok {
+			return versions.([]Version), nil
+		}
+	}
+
+	var versions []Version
+
+	switch entry.InstallerType {
+	case "zip-portable":
+		versions, err = g.handleZipPortable(ctx, entry, releases)
+	default:
+		return nil, fmt.Errorf("unknown installer type: %s", entry.InstallerType)
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("gitlab releases API: %w", err)
+		return nil, err
+	}
+
+	g.Validators.Remember(entry, versionsKey, versions)
+
+	return versions, nil
+}
+
+// fetchReleases follows the X-Next-Page header of the releases API until the last page or entry.MaxReleases is reached.
+func (g Gitlab) fetchReleases(ctx context.Context, entry PackageListEntry) ([]gitlabRelease, bool, error) {
+	releases := []gitlabRelease{}
+	modified := false
+
+	for page := "1"; len(page) != 0; {
+		url := fmt.Sprintf("%s/api/v4/projects/%d/releases?per_page=%d&page=%s", entry.Endpoint, entry.ProjectID, gitlabReleasesPerPage, page)
+
+		found, next, changed, err := g.fetchReleasesPage(ctx, entry, url)
+		if err != nil {
+			return nil, false, err
+		}
+
+		modified = modified || changed
+		releases = append(releases, found...)
+
+		if entry.MaxReleases > 0 && len(releases) >= entry.MaxReleases {
+			return releases[:entry.MaxReleases], modified, nil
+		}
+
+		page = next
+	}
+
+	return releases, modified, nil
+}
+
+type gitlabReleasesPage struct {
+	releases []gitlabRelease
+	next     string
+}
+
+// fetchReleasesPage returns the releases of a page, the next page and whether the page changed since it was last fetched.
+func (g Gitlab) fetchReleasesPage(ctx context.Context, entry PackageListEntry, url string) ([]gitlabRelease, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("gitlab releases API: %w", err)
 	}
 
 	if len(entry.Token) != 0 {
 		req.Header.Add("PRIVATE-TOKEN", entry.Token)
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	g.Validators.Apply(req)
+
+	res, err := g.client().Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("gitlab releases API: %w", err)
+		return nil, "", false, fmt.Errorf("gitlab releases API: %w", err)
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusNotModified {
+		if page, ok := g.Validators.Lookup(url); ok {
+			return page.(gitlabReleasesPage).releases, page.(gitlabReleasesPage).next, false, nil
+		}
+	}
+
 	if res.StatusCode != 200 {
-		contents, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("gitlab releases API status %d: %s", res.StatusCode, contents)
+		return nil, "", false, upstreamStatusError("gitlab releases API", res)
 	}
 
 	releases := []gitlabRelease{}
 	if err := json.NewDecoder(res.Body).Decode(&releases); err != nil {
-		return nil, fmt.Errorf("gitlab releases API response decode: %w", err)
+		return nil, "", false, fmt.Errorf("gitlab releases API response decode: %w", err)
 	}
 
-	switch entry.InstallerType {
-	case "zip-portable":
-		return g.handleZipPortable(entry, releases)
-	default:
-		return nil, fmt.Errorf("unknown installer type: %s", entry.InstallerType)
-	}
+	next := strings.TrimSpace(res.Header.Get("X-Next-Page"))
+	g.Validators.Store(entry, url, res.Header, gitlabReleasesPage{releases: releases, next: next})
 
+	return releases, next, true, nil
 }
 
-func (g Gitlab) handleZipPortable(entry PackageListEntry, releases []gitlabRelease) ([]Version, error) {
+func (g Gitlab) handleZipPortable(ctx context.Context, entry PackageListEntry, releases []gitlabRelease) ([]Version, error) {
+	found := make([]*Version, len(releases))
+	errs := make([]error, len(releases))
+
+	parallel(len(releases), releaseWorkers, func(i int) {
+		found[i], errs[i] = g.zipPortableVersion(ctx, entry, releases[i])
+	})
+
 	versions := []Version{}
 
-	for _, release := range releases {
-		installers := []Installer{}
-
-		checksums := map[string]string{}
-
-		for _, link := range release.Assets.Links {
-			lname := strings.ToLower(link.Name)
-			if strings.Contains(lname, "checksum") {
-				checkSumRes, err := http.Get(link.Url)
-				if err != nil {
-					return nil, fmt.Errorf("checksum download: %w", err)
-				}
-				defer checkSumRes.Body.Close()
-
-				if checkSumRes.StatusCode != 200 {
-					contents, _ := io.ReadAll(checkSumRes.Body)
-					return nil, fmt.Errorf("checksum download status %d: %s", checkSumRes.StatusCode, contents)
-				}
-
-				scanner := bufio.NewScanner(checkSumRes.Body)
-				for scanner.Scan() {
-					line := scanner.Text()
-					if err := scanner.Err(); err != nil {
-						return nil, fmt.Errorf("checksum read: %w", err)
-					}
-
-					fields := strings.Fields(line)
-					if len(fields) != 2 {
-						return nil, fmt.Errorf("checksum format error")
-					}
-
-					checksums[fields[1]] = fields[0]
-				}
-			}
+	for i := range releases {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		if found[i] == nil {
+			continue
+		}
 
-			if !(strings.Contains(lname, "windows") && strings.HasSuffix(lname, ".zip")) {
-				continue
+		versions = append(versions, *found[i])
+	}
+
+	return versions, nil
+}
+
+// zipPortableVersion builds the version of a single release, or nil if the release has no Windows zip asset.
+func (g Gitlab) zipPortableVersion(ctx context.Context, entry PackageListEntry, release gitlabRelease) (*Version, error) {
+	installers := []Installer{}
+
+	checksums := map[string]string{}
+
+	for _, link := range release.Assets.Links {
+		lname := strings.ToLower(link.Name)
+		if strings.Contains(lname, "checksum") {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.Url, nil)
+			if err != nil {
+				return nil, fmt.Errorf("checksum download: %w", err)
 			}
 
-			var arch string
-			if strings.Contains(lname, "x86_64") || strings.Contains(lname, "x64") {
-				arch = "x64"
-			} else if strings.Contains(lname, "i386") || strings.Contains(lname, "x86") {
-				arch = "x86"
-			} else if strings.Contains(lname, "arm64") {
-				arch = "arm64"
-			} else {
-				continue
+			found, err := fetchChecksums(g.client(), g.Validators, g.Cache, entry, req)
+			if err != nil {
+				return nil, err
 			}
 
-			checksum, ok := checksums[link.Name]
-			if !ok {
-				checksum = ""
+			for name, checksum := range found {
+				checksums[name] = checksum
 			}
+		}
 
-			installers = append(installers, Installer{
-				Architecture:        arch,
-				InstallerType:       "zip",
-				InstallerUrl:        link.Url,
-				InstallerSha256:     checksum,
-				Scope:               "user",
-				NestedInstallerType: "portable",
-				NestedInstallerFiles: []NestedInstallerFile{
-					{
-						RelativeFilePath: fmt.Sprintf("%s.exe", entry.Name),
-					},
-				},
-			})
+		if !(strings.Contains(lname, "windows") && strings.HasSuffix(lname, ".zip")) {
+			continue
 		}
 
-		if len(installers) == 0 {
+		var arch string
+		if strings.Contains(lname, "x86_64") || strings.Contains(lname, "x64") {
+			arch = "x64"
+		} else if strings.Contains(lname, "i386") || strings.Contains(lname, "x86") {
+			arch = "x86"
+		} else if strings.Contains(lname, "arm64") {
+			arch = "arm64"
+		} else {
 			continue
 		}
 
-		versions = append(versions, Version{
-			Version:    release.Name,
-			Installers: installers,
+		checksum, ok := checksums[link.Name]
+		if !ok {
+			checksum = ""
+		}
+
+		installers = append(installers, Installer{
+			Architecture:        arch,
+			InstallerType:       "zip",
+			InstallerUrl:        link.Url,
+			InstallerSha256:     checksum,
+			Scope:               "user",
+			NestedInstallerType: "portable",
+			NestedInstallerFiles: []NestedInstallerFile{
+				{
+					RelativeFilePath: fmt.Sprintf("%s.exe", entry.Name),
+				},
+			},
 		})
 	}
 
-	return versions, nil
+	if len(installers) == 0 {
+		return nil, nil
+	}
+
+	return &Version{
+		Version:    release.Name,
+		Installers: installers,
+	}, nil
+}
+
+// Retain implements entryRetainer.
+func (g Gitlab) Retain(packageList []PackageListEntry) {
+	g.Validators.Retain(packageList)
 }
 
 var _ PackageProvider = Gitlab{}
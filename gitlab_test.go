@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGitlabFetchReleasesFollowsPages(t *testing.T) {
+	pages := map[string]struct {
+		body string
+		next string
+	}{
+		"1": {`[{"name": "v3"}, {"name": "v2"}]`, "2"},
+		"2": {`[{"name": "v1"}]`, ""},
+	}
+
+	requested := []string{}
+	g := Gitlab{Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		page := pages[req.URL.Query().Get("page")]
+		// GitLab sends an empty X-Next-Page on the last page
+		header := http.Header{"X-Next-Page": []string{page.next}}
+		return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(strings.NewReader(page.body)), Request: req}, nil
+	})}}
+
+	entry := PackageListEntry{Endpoint: "https://gitlab.example.com", ProjectID: 42}
+
+	releases, _, err := g.fetchReleases(context.Background(), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, release := range releases {
+		names = append(names, release.Name)
+	}
+
+	want := []string{
+		"https://gitlab.example.com/api/v4/projects/42/releases?per_page=100&page=1",
+		"https://gitlab.example.com/api/v4/projects/42/releases?per_page=100&page=2",
+	}
+	if strings.Join(names, " ") != "v3 v2 v1" || strings.Join(requested, " ") != strings.Join(want, " ") {
+		t.Errorf("releases %v from %v, want [v3 v2 v1] from %v", names, requested, want)
+	}
+
+	// the next page is not requested once enough releases are found
+	requested = nil
+	entry.MaxReleases = 2
+
+	releases, _, err = g.fetchReleases(context.Background(), entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || len(requested) != 1 {
+		t.Errorf("max_releases 2: %d releases in %d requests, want 2 in 1", len(releases), len(requested))
+	}
+}
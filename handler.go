@@ -2,20 +2,63 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-func NewWingetSrcHandler(service WingetSrcService) http.Handler {
+// failedPackagesHeader lists the identifiers omitted from a search because their provider failed.
+const failedPackagesHeader = "X-Winget-Src-Failed-Packages"
+
+type HandlerOptions struct {
+	// Timeout bounds the handling of a request; 0 disables it.
+	Timeout time.Duration
+	// Ready backs /readyz, which fails until the initial sync is done.
+	Ready func() bool
+	// Webhooks configures the release webhook receivers.
+	Webhooks WebhookOptions
+	// Snapshot backs /snapshot, reporting how old the persisted manifests are; the endpoint is disabled when nil.
+	Snapshot func() SnapshotStatus
+}
+
+func NewWingetSrcHandler(service WingetSrcService, options HandlerOptions) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	if options.Timeout > 0 {
+		r.Use(middleware.Timeout(options.Timeout))
+	}
+
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !options.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mountWebhooks(r, service, options.Webhooks)
+
+	if options.Snapshot != nil {
+		r.Get("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(DataResponse{
+				Data: options.Snapshot(),
+			})
+		})
+	}
 
 	r.Get("/information", func(w http.ResponseWriter, r *http.Request) {
 		res, _ := service.Information()
@@ -35,20 +78,24 @@ func NewWingetSrcHandler(service WingetSrcService) http.Handler {
 			json.NewEncoder(w).Encode(ErrorResponse{
 				{
 					ErrorCode:    http.StatusBadRequest,
-					ErrorMessage: err.Error(),
+					ErrorMessage: redactor.Redact(err.Error()),
 				},
 			})
 			return
 		}
 
-		res, err := service.ManifestSearch(req)
-		if err != nil {
+		res, err := service.ManifestSearch(r.Context(), req)
+
+		var partial *PartialResultError
+		if errors.As(err, &partial) {
+			w.Header().Add(failedPackagesHeader, strings.Join(partial.Identifiers(), ","))
+		} else if err != nil {
 			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(ErrorResponse{
 				{
 					ErrorCode:    http.StatusInternalServerError,
-					ErrorMessage: err.Error(),
+					ErrorMessage: redactor.Redact(err.Error()),
 				},
 			})
 			return
@@ -65,14 +112,14 @@ func NewWingetSrcHandler(service WingetSrcService) http.Handler {
 		identifier := chi.URLParam(r, "identifier")
 		version := r.URL.Query().Get("Version")
 
-		res, err := service.PackageManifests(identifier, version)
+		res, err := service.PackageManifests(r.Context(), identifier, version)
 		if err != nil {
 			w.Header().Add("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(ErrorResponse{
 				{
 					ErrorCode:    http.StatusInternalServerError,
-					ErrorMessage: err.Error(),
+					ErrorMessage: redactor.Redact(err.Error()),
 				},
 			})
 			return
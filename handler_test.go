@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type providerFunc func(ctx context.Context, entry PackageListEntry) ([]Version, error)
+
+func (f providerFunc) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	return f(ctx, entry)
+}
+
+func TestManifestSearchWithFailingPackages(t *testing.T) {
+	provider := providerFunc(func(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+		if entry.Id == "Broken.Package" {
+			return nil, errors.New("upstream failed")
+		}
+		return []Version{{Version: "1.0.0"}}, nil
+	})
+
+	packageList := []PackageListEntry{
+		{Id: "Working.Package", Name: "working"},
+		{Id: "Broken.Package", Name: "broken"},
+	}
+
+	tests := []struct {
+		strict  bool
+		status  int
+		header  string
+		results int
+	}{
+		{strict: false, status: http.StatusOK, header: "Broken.Package", results: 1},
+		{strict: true, status: http.StatusInternalServerError, header: "", results: 0},
+	}
+
+	for _, test := range tests {
+		repository := NewWingetSrcRepository(packageList, provider, NewManifestIndex(), 2)
+		handler := NewWingetSrcHandler(NewWingetSrcService(repository, ServiceOptions{Strict: test.strict}), HandlerOptions{})
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/manifestSearch", strings.NewReader("{}")))
+
+		if rec.Code != test.status || rec.Header().Get(failedPackagesHeader) != test.header {
+			t.Errorf("strict %v: status %d with failed packages %q, want %d with %q", test.strict, rec.Code, rec.Header().Get(failedPackagesHeader), test.status, test.header)
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var res struct{ Data []Manifest }
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Data) != test.results {
+			t.Errorf("strict %v: %d results, want %d", test.strict, len(res.Data), test.results)
+		}
+	}
+}
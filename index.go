@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManifestIndex holds the resolved manifests of every package so that queries never wait for upstreams.
+type ManifestIndex struct {
+	mu       sync.RWMutex
+	packages map[string]PackageManifests
+	// restored marks packages loaded from a snapshot and not refreshed since.
+	restored map[string]bool
+	// refreshedAt records when the manifests of each package were last resolved from its upstream.
+	refreshedAt map[string]time.Time
+}
+
+func NewManifestIndex() *ManifestIndex {
+	return &ManifestIndex{
+		packages:    map[string]PackageManifests{},
+		restored:    map[string]bool{},
+		refreshedAt: map[string]time.Time{},
+	}
+}
+
+func (i *ManifestIndex) Get(identifier string) (PackageManifests, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	pkgManifests, ok := i.packages[identifier]
+
+	return pkgManifests, ok
+}
+
+// Put replaces the manifests of the package and reports which versions appeared or disappeared.
+func (i *ManifestIndex) Put(pkgManifests PackageManifests) VersionDiff {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	previous := i.packages[pkgManifests.PackageIdentifier]
+	i.packages[pkgManifests.PackageIdentifier] = pkgManifests
+	delete(i.restored, pkgManifests.PackageIdentifier)
+	i.refreshedAt[pkgManifests.PackageIdentifier] = time.Now()
+
+	return diffVersions(previous, pkgManifests)
+}
+
+// Retain drops every package whose identifier is not listed.
+func (i *ManifestIndex) Retain(identifiers []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	keep := map[string]bool{}
+	for _, id := range identifiers {
+		keep[id] = true
+	}
+
+	for id := range i.packages {
+		if !keep[id] {
+			delete(i.packages, id)
+			delete(i.restored, id)
+			delete(i.refreshedAt, id)
+		}
+	}
+}
+
+// All returns every indexed package ordered by identifier.
+func (i *ManifestIndex) All() []PackageManifests {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	all := []PackageManifests{}
+	for _, pkgManifests := range i.packages {
+		all = append(all, pkgManifests)
+	}
+
+	sort.Slice(all, func(a, b int) bool {
+		return all[a].PackageIdentifier < all[b].PackageIdentifier
+	})
+
+	return all
+}
+
+// Restore fills the index with packages loaded from a snapshot, without replacing fresher ones.
+// refreshedAt gives when each package was last refreshed before the snapshot was written.
+func (i *ManifestIndex) Restore(packages []PackageManifests, refreshedAt map[string]time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, pkgManifests := range packages {
+		if _, ok := i.packages[pkgManifests.PackageIdentifier]; ok {
+			continue
+		}
+
+		i.packages[pkgManifests.PackageIdentifier] = pkgManifests
+		i.restored[pkgManifests.PackageIdentifier] = true
+		i.refreshedAt[pkgManifests.PackageIdentifier] = refreshedAt[pkgManifests.PackageIdentifier]
+	}
+}
+
+// RefreshedAt returns when each indexed package was last refreshed.
+func (i *ManifestIndex) RefreshedAt() map[string]time.Time {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	refreshedAt := map[string]time.Time{}
+	for id, at := range i.refreshedAt {
+		refreshedAt[id] = at
+	}
+
+	return refreshedAt
+}
+
+// Restored returns the identifiers still served from a snapshot because they could not be refreshed yet.
+func (i *ManifestIndex) Restored() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	ids := []string{}
+	for id := range i.restored {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+type VersionDiff struct {
+	Added   []string
+	Removed []string
+}
+
+func (d VersionDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+func diffVersions(before PackageManifests, after PackageManifests) VersionDiff {
+	known := map[string]bool{}
+	for _, version := range before.Versions {
+		known[version.PackageVersion] = true
+	}
+
+	diff := VersionDiff{}
+
+	for _, version := range after.Versions {
+		if known[version.PackageVersion] {
+			delete(known, version.PackageVersion)
+			continue
+		}
+		diff.Added = append(diff.Added, version.PackageVersion)
+	}
+
+	for _, version := range before.Versions {
+		if known[version.PackageVersion] {
+			diff.Removed = append(diff.Removed, version.PackageVersion)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in the package list, located at a line and column of the file.
+type Diagnostic struct {
+	Path     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func newDiagnostic(path string, node *yaml.Node, severity Severity, message string) Diagnostic {
+	return Diagnostic{
+		Path:     path,
+		Line:     node.Line,
+		Column:   node.Column,
+		Severity: severity,
+		Message:  message,
+	}
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.Path, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// diagnosticsError joins the error diagnostics, or returns nil when there are only warnings.
+func diagnosticsError(diagnostics []Diagnostic) error {
+	messages := []string{}
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			messages = append(messages, d.String())
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(messages, "; "))
+}
+
+func sortDiagnostics(diagnostics []Diagnostic) {
+	sort.SliceStable(diagnostics, func(a, b int) bool {
+		if diagnostics[a].Path != diagnostics[b].Path {
+			return diagnostics[a].Path < diagnostics[b].Path
+		}
+		if diagnostics[a].Line != diagnostics[b].Line {
+			return diagnostics[a].Line < diagnostics[b].Line
+		}
+		return diagnostics[a].Column < diagnostics[b].Column
+	})
+}
+
+// packageIdentifierPattern is the PackageIdentifier rule of the winget manifest schema.
+var packageIdentifierPattern = regexp.MustCompile(`^[^\.\s\\/:\*\?"<>\|\x01-\x1f]{1,32}(\.[^\.\s\\/:\*\?"<>\|\x01-\x1f]{1,32}){1,7}$`)
+
+const packageIdentifierMaxLength = 128
+
+var supportedInstallerTypes = []string{"zip-portable"}
+
+// LintPackageList parses the package list and its overlays and checks every entry, returning all the
+// diagnostics sorted by position. root is the local copy of a remote package list, see parsePackageList.
+func LintPackageList(path string, overlays []string, root string) (parsedPackageList, []Diagnostic, error) {
+	f, diagnostics, err := parsePackageList(path, overlays, root)
+	if err != nil {
+		return f, nil, err
+	}
+
+	for _, name := range providerProfileNames(f.providers) {
+		diagnostics = append(diagnostics, lintProviderProfile(f, name)...)
+	}
+
+	seen := map[string]nodeRef{}
+
+	for i, entry := range f.entries {
+		switch {
+		case len(entry.Id) == 0:
+			diagnostics = append(diagnostics, f.at(i, "id", SeverityError, "id is required"))
+		case len(entry.Id) > packageIdentifierMaxLength || !packageIdentifierPattern.MatchString(entry.Id):
+			diagnostics = append(diagnostics, f.at(i, "id", SeverityError, "id %q is not a winget package identifier, expected Publisher.Package with up to 8 parts", entry.Id))
+		}
+
+		if first, ok := seen[entry.Id]; ok && len(entry.Id) != 0 {
+			diagnostics = append(diagnostics, f.at(i, "id", SeverityError, "duplicate id %s, first defined at %s:%d", entry.Id, first.path, first.node.Line))
+		} else {
+			seen[entry.Id] = f.entryRefs[i][0]
+		}
+
+		// only the token set on the entry itself is linted here, the one of its profile is linted with the profile
+		diagnostics = append(diagnostics, lintToken(entry.Token, func(severity Severity, format string, args ...any) Diagnostic {
+			return f.at(i, "token", severity, format, args...)
+		})...)
+
+		// profiles may be defined in any file, so entries are only resolved once every file is merged
+		f.entries[i] = resolveEntry(entry, f.providers)
+
+		diagnostics = append(diagnostics, lintEntry(f, i)...)
+	}
+
+	sortDiagnostics(diagnostics)
+
+	return f, diagnostics, nil
+}
+
+func lintEntry(f parsedPackageList, i int) []Diagnostic {
+	entry := f.entries[i]
+	diagnostics := []Diagnostic{}
+
+	profiles := withBuiltinProviderProfiles(f.providers)
+
+	switch _, ok := profiles[entry.Provider]; {
+	case len(entry.Provider) == 0:
+		diagnostics = append(diagnostics, f.at(i, "provider", SeverityError, "provider is required"))
+	case !ok:
+		diagnostics = append(diagnostics, f.at(i, "provider", SeverityError, "unknown provider %q, expected one of %s", entry.Provider, strings.Join(providerProfileNames(profiles), ", ")))
+	}
+
+	switch entry.Type {
+	case "github":
+		if len(entry.Owner) == 0 && len(entry.Publisher) == 0 {
+			diagnostics = append(diagnostics, f.at(i, "owner", SeverityError, "github entries need owner, or publisher as the owner"))
+		}
+		if len(entry.Repo) == 0 && len(entry.Name) == 0 {
+			diagnostics = append(diagnostics, f.at(i, "repo", SeverityError, "github entries need repo, or name as the repository"))
+		}
+	case "gitlab":
+		if len(entry.Endpoint) == 0 {
+			diagnostics = append(diagnostics, f.at(i, "endpoint", SeverityError, "gitlab entries need endpoint, set on the entry or its provider profile"))
+		}
+		if entry.ProjectID == 0 {
+			diagnostics = append(diagnostics, f.at(i, "project_id", SeverityError, "gitlab entries need project_id"))
+		}
+	}
+
+	if len(entry.Endpoint) != 0 && !isHTTPURL(entry.Endpoint) {
+		diagnostics = append(diagnostics, f.at(i, "endpoint", SeverityError, "endpoint %q is not an http(s) URL", entry.Endpoint))
+	}
+
+	supported := false
+	for _, installerType := range supportedInstallerTypes {
+		supported = supported || entry.InstallerType == installerType
+	}
+	if !supported {
+		diagnostics = append(diagnostics, f.at(i, "installer_type", SeverityError, "unsupported installer_type %q, expected one of %s", entry.InstallerType, strings.Join(supportedInstallerTypes, ", ")))
+	}
+
+	if entry.MaxReleases < 0 {
+		diagnostics = append(diagnostics, f.at(i, "max_releases", SeverityError, "max_releases must not be negative"))
+	}
+
+	if entry.SyncInterval < 0 {
+		diagnostics = append(diagnostics, f.at(i, "sync_interval", SeverityError, "sync_interval must not be negative"))
+	}
+
+	if len(entry.Name) == 0 {
+		diagnostics = append(diagnostics, f.at(i, "name", SeverityWarning, "name is empty, winget shows it as the package name"))
+	}
+
+	if len(entry.Publisher) == 0 {
+		diagnostics = append(diagnostics, f.at(i, "publisher", SeverityWarning, "publisher is empty, winget shows it as the package publisher"))
+	}
+
+	return diagnostics
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) != 0
+}
+
+func lintProviderProfile(f parsedPackageList, name string) []Diagnostic {
+	profile := f.providers[name]
+	diagnostics := []Diagnostic{}
+
+	known := false
+	for _, providerType := range providerTypes {
+		known = known || profile.Type == providerType
+	}
+	if !known {
+		diagnostics = append(diagnostics, f.providerAt(name, "type", SeverityError, "unknown provider type %q, expected one of %s", profile.Type, strings.Join(providerTypes, ", ")))
+	}
+
+	if len(profile.Endpoint) != 0 && !isHTTPURL(profile.Endpoint) {
+		diagnostics = append(diagnostics, f.providerAt(name, "endpoint", SeverityError, "endpoint %q is not an http(s) URL", profile.Endpoint))
+	}
+
+	diagnostics = append(diagnostics, lintToken(profile.Token, func(severity Severity, format string, args ...any) Diagnostic {
+		return f.providerAt(name, "token", severity, format, args...)
+	})...)
+
+	for _, header := range sortedKeys(profile.HTTP.Headers) {
+		if value := profile.HTTP.Headers[header]; isEncrypted(value) {
+			if err := checkEncrypted(value); err != nil {
+				diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityError, "header %s: %s", header, err))
+			}
+		}
+	}
+
+	if len(profile.HTTP.Headers) != 0 && len(profile.host()) == 0 {
+		diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityWarning, "headers are only sent to the endpoint host, set endpoint for them to be sent"))
+	}
+
+	if len(profile.HTTP.Proxy) != 0 && !isHTTPURL(profile.HTTP.Proxy) {
+		diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityError, "proxy %q is not an http(s) URL", profile.HTTP.Proxy))
+	}
+
+	if profile.HTTP.Timeout < 0 {
+		diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityError, "timeout must not be negative"))
+	}
+
+	if profile.HTTP.InsecureSkipVerify {
+		diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityWarning, "TLS certificates of %s are not verified", name))
+	}
+
+	return diagnostics
+}
+
+// lintToken checks the syntax of a token reference without resolving or decrypting it, so that lists can be
+// validated where the secrets are not available.
+func lintToken(token string, at func(severity Severity, format string, args ...any) Diagnostic) []Diagnostic {
+	switch {
+	case len(token) == 0:
+		return nil
+	case isEncrypted(token):
+		if err := checkEncrypted(token); err != nil {
+			return []Diagnostic{at(SeverityError, "token: %s", err)}
+		}
+	case token == secretEnvPrefix || token == secretFilePrefix:
+		return []Diagnostic{at(SeverityError, "token reference %q names no secret", token)}
+	case !isSecretReference(token):
+		return []Diagnostic{at(SeverityWarning, "token is in plain text, reference it with %sNAME or %sPATH, or encrypt it", secretEnvPrefix, secretFilePrefix)}
+	}
+
+	return nil
+}
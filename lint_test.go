@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLintPackageListWarnsOnceAboutProfileTokens(t *testing.T) {
+	contents := `
+providers:
+  corp:
+    type: github
+    token: plain-profile-token
+packages:
+  - provider: corp
+    id: A.B
+    name: b
+    publisher: a
+  - provider: corp
+    id: C.D
+    name: d
+    publisher: c
+    token: plain-entry-token
+`
+
+	path := filepath.Join(t.TempDir(), "list.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, diagnostics, err := LintPackageList(path, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []int{}
+	for _, diagnostic := range diagnostics {
+		if strings.HasPrefix(diagnostic.Message, "token is in plain text") {
+			lines = append(lines, diagnostic.Line)
+		}
+	}
+
+	// once on the profile and once on the entry setting its own token, not on the entry inheriting it
+	if len(lines) != 2 || lines[0] != 5 || lines[1] != 15 {
+		t.Errorf("plain text token warnings on lines %v, want [5 15]", lines)
+	}
+
+	if f.entries[0].Token != "plain-profile-token" || f.entries[1].Token != "plain-entry-token" {
+		t.Errorf("entries resolved to tokens %q and %q", f.entries[0].Token, f.entries[1].Token)
+	}
+}
+
+func TestLintPackageListAcceptsShortSyncIntervals(t *testing.T) {
+	contents := `
+- provider: github
+  id: A.B
+  name: b
+  publisher: a
+  installer_type: zip-portable
+  sync_interval: 10s
+- provider: github
+  id: C.D
+  name: d
+  publisher: c
+  installer_type: zip-portable
+  sync_interval: -1s
+`
+
+	path := filepath.Join(t.TempDir(), "list.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, diagnostics, err := LintPackageList(path, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the scheduler wakes up for the earliest entry due, however short its interval
+	if len(diagnostics) != 1 || diagnostics[0].Line != 13 || diagnostics[0].Severity != SeverityError {
+		t.Errorf("diagnostics = %v, want only the negative sync_interval", diagnostics)
+	}
+}
+
+func TestLintPackageListConfinesRemoteLists(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "checkout")
+	entry := "- provider: github\n  id: %s\n  name: b\n  publisher: a\n  installer_type: zip-portable\n"
+
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "outside.yaml"):     strings.Replace(entry, "%s", "Outside.Package", 1),
+		filepath.Join(root, "lists", "a.yaml"): strings.Replace(entry, "%s", "Inside.Package", 1),
+	})
+	if err := os.Symlink(filepath.Join(dir, "outside.yaml"), filepath.Join(root, "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		include string
+		escapes bool
+	}{
+		{"lists/*.yaml", false},
+		{"lists/../lists/a.yaml", false},
+		{"../outside.yaml", true},
+		{filepath.Join(dir, "outside.yaml"), true},
+		{"link.yaml", true},
+		{"*.yaml", true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(root, "list.yaml")
+		writeFiles(t, map[string]string{path: "include:\n  - " + strconv.Quote(test.include) + "\n"})
+
+		if _, _, err := LintPackageList(path, nil, ""); err != nil {
+			t.Errorf("%s: local list: %v", test.include, err)
+		}
+
+		_, _, err := LintPackageList(path, nil, root)
+		if escapes := err != nil; escapes != test.escapes {
+			t.Errorf("%s: remote list error %v, want an error %v", test.include, err, test.escapes)
+		}
+	}
+}
+
+func TestResolveSecretsConfinesRemoteLists(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "checkout")
+
+	t.Setenv("ALLOWED_TOKEN", "allowed-secret")
+	t.Setenv("OTHER_SECRET", "other-secret")
+
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "outside.txt"): "outside-secret",
+		filepath.Join(root, "token.txt"):  "inside-secret",
+	})
+	if err := os.Symlink(filepath.Join(dir, "outside.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		token    string
+		resolves bool
+	}{
+		{"env:ALLOWED_TOKEN", true},
+		{"env:OTHER_SECRET", false},
+		{"file:token.txt", true},
+		{"file:../outside.txt", false},
+		{"file:" + filepath.Join(dir, "outside.txt"), false},
+		{"file:link.txt", false},
+	}
+
+	resolver := &SecretResolver{RemoteEnv: []string{"ALLOWED_TOKEN"}}
+
+	for _, test := range tests {
+		path := filepath.Join(root, "list.yaml")
+		writeFiles(t, map[string]string{path: "- provider: github\n  id: A.B\n  token: " + strconv.Quote(test.token) + "\n"})
+
+		for _, confined := range []string{"", root} {
+			f, _, err := LintPackageList(path, nil, confined)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diagnostics := resolveSecrets(&f, resolver, confined)
+			if resolves := len(diagnostics) == 0; resolves != (test.resolves || confined == "") {
+				t.Errorf("%s with root %q: diagnostics %v", test.token, confined, diagnostics)
+			}
+		}
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
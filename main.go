@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
@@ -16,49 +19,232 @@ const (
 	exitErr
 )
 
-func run() int {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+// newHTTPClient builds the client of a provider profile, which bounds, retries and breaks upstream requests.
+// limiter is shared by every client so that the concurrency limits hold across profiles.
+func newHTTPClient(config Config, limiter *ConcurrencyLimiter, profile ProviderProfile) (*http.Client, error) {
+	settings := profile.HTTP
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if len(settings.Proxy) != 0 || settings.InsecureSkipVerify {
+		base := http.DefaultTransport.(*http.Transport).Clone()
+
+		if len(settings.Proxy) != 0 {
+			proxy, err := url.Parse(settings.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("proxy: %w", err)
+			}
+			base.Proxy = http.ProxyURL(proxy)
+		}
+
+		if settings.InsecureSkipVerify {
+			base.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+
+		transport = base
+	}
+
+	transport = NewLimitedTransport(transport, limiter)
+	transport = NewCircuitBreakerTransport(transport, BreakerOptions{
+		Threshold: config.Upstream.BreakerThreshold,
+		Cooldown:  config.Upstream.BreakerCooldown,
+	})
+	transport = NewRetryTransport(transport, RetryOptions{
+		MaxRetries: config.Upstream.Retries,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   10 * time.Second,
+	})
+	if len(settings.Headers) != 0 {
+		transport = NewHeaderTransport(transport, profile.host(), settings.Headers)
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// newUpstreamProvider builds the registry of the providers that fetch versions straight from the upstreams,
+// configured with profiles.
+func newUpstreamProvider(config Config, cacheBackend CacheBackend, profiles map[string]ProviderProfile) (*ProviderRegistry, error) {
+	limiter := NewConcurrencyLimiter(config.Upstream.Concurrency, config.Upstream.HostConcurrency)
+	validators := NewValidatorCache()
+	tokens := NewGithubTokenPool(githubDefaultHost, config.Upstream.GithubTokens)
+
+	timeout := func(profile ProviderProfile, defaultValue time.Duration) time.Duration {
+		if profile.HTTP.Timeout > 0 {
+			return profile.HTTP.Timeout
+		}
+		return defaultValue
+	}
+
+	registry, err := NewProviderRegistry(map[string]ProviderFactory{
+		"github": func(profile ProviderProfile) (PackageProvider, error) {
+			client, err := newHTTPClient(config, limiter, profile)
+			if err != nil {
+				return nil, err
+			}
+			return Github{Timeout: timeout(profile, config.Timeouts.Github), Client: client, Validators: validators, Cache: cacheBackend, Tokens: tokens}, nil
+		},
+		"gitlab": func(profile ProviderProfile) (PackageProvider, error) {
+			client, err := newHTTPClient(config, limiter, profile)
+			if err != nil {
+				return nil, err
+			}
+			return Gitlab{Timeout: timeout(profile, config.Timeouts.Gitlab), Client: client, Validators: validators, Cache: cacheBackend}, nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := registry.Configure(profiles); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+func serve(config Config, args []string) int {
+	if config.PackageList == "" {
+		slog.Error("package list is required, set package_list, PACKAGE_LIST or -package-list")
+		return exitErr
+	}
+
+	cacheBackend, err := NewCacheBackend(config.Cache.Backend, config.Cache.Dir, config.Cache.RedisUrl)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	resolver, err := config.SecretResolver()
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
 	}
 
-	pacakgeListPath := os.Getenv("PACKAGE_LIST")
-	if pacakgeListPath == "" {
-		slog.Error("env var PACKAGE_LIST is required")
+	source, err := NewPackageListSource(config.PackageList, config.Timeouts.PackageList)
+	if err != nil {
+		slog.Error(err.Error())
 		return exitErr
 	}
+	defer source.Close()
+
+	load := func(ctx context.Context) (PackageList, error) {
+		path, err := source.Fetch(ctx)
+		if err != nil {
+			return PackageList{}, err
+		}
 
-	repository, err := NewWingetSrcRepository(pacakgeListPath)
+		return LoadPackageList(path, config.PackageListOverlays, source.Root(), resolver)
+	}
+
+	packageList, err := load(context.Background())
 	if err != nil {
 		slog.Error(err.Error())
 		return exitErr
 	}
-	service := NewWingetSrcService(repository)
-	handler := NewWingetSrcHandler(service)
+
+	registry, err := newUpstreamProvider(config, cacheBackend, packageList.Providers)
+	if err != nil {
+		slog.Error(err.Error())
+		return exitErr
+	}
+
+	var provider PackageProvider = registry
+	provider = NewCoalescingProvider(provider)
+	provider = NewFallbackProvider(provider)
+	if config.Cache.TTL > 0 {
+		provider = NewCachedProvider(provider, cacheBackend, CacheOptions{
+			TTL:         config.Cache.TTL,
+			StaleTTL:    config.Cache.StaleTTL,
+			NegativeTTL: config.Cache.NegativeTTL,
+		})
+	}
+
+	index := NewManifestIndex()
+
+	var snapshotter *Snapshotter
+	if config.Snapshot.Path != "" {
+		snapshotter = NewSnapshotter(config.Snapshot.Path, index)
+		if err := snapshotter.Load(); err != nil {
+			slog.Error("snapshot load failed", "path", config.Snapshot.Path, "error", err)
+		}
+	}
+
+	repository := NewWingetSrcRepository(packageList.Packages, provider, index, config.Upstream.Concurrency)
+
+	reloader := NewPackageListReloader(config.PackageList, load, packageList, repository, registry, config.Upstream.Concurrency)
+
+	syncer := NewSyncer(repository, config.Sync.Interval, config.Sync.Jitter, config.Upstream.Concurrency)
+
+	service := NewWingetSrcService(repository, ServiceOptions{
+		Information: InformationResponse{
+			SourceIdentifier:        config.SourceIdentifier,
+			ServerSupportedVersions: config.ServerSupportedVersions,
+		},
+		Strict: config.StrictSearch,
+	})
+	handlerOptions := HandlerOptions{
+		Ready:   syncer.Ready,
+		Timeout: config.Timeouts.Request,
+		Webhooks: WebhookOptions{
+			GithubSecret: config.Webhooks.GithubSecret,
+			GitlabToken:  config.Webhooks.GitlabToken,
+		},
+	}
+	if snapshotter != nil {
+		handlerOptions.Snapshot = snapshotter.Status
+	}
+
+	handler := NewWingetSrcHandler(service, handlerOptions)
 
 	srv := &http.Server{
-		Addr:              ":" + port,
+		Addr:              config.Listen,
 		Handler:           handler,
-		ReadHeaderTimeout: 30 * time.Second,
+		ReadHeaderTimeout: config.Timeouts.ReadHeader,
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 
 	defer stop()
 
+	go syncer.Run(ctx)
+	pollInterval := config.Sync.PackageListPollInterval
+	if isRemoteSource(config.PackageList) {
+		pollInterval = config.Sync.RemotePackageListPollInterval
+	}
+	go reloader.Run(ctx, pollInterval)
+
+	snapshotDone := make(chan struct{})
+	if snapshotter != nil {
+		go func() {
+			defer close(snapshotDone)
+			snapshotter.Run(ctx, config.Snapshot.Interval)
+		}()
+	} else {
+		close(snapshotDone)
+	}
+
+	listenErr := make(chan error, 1)
 	go func() {
-		slog.Info("start server listen")
+		slog.Info("start server listen", "addr", config.Listen)
 
-		if err := srv.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
-			slog.Info(err.Error())
-		}
+		listenErr <- srv.ListenAndServe()
 	}()
 
-	<-ctx.Done()
+	select {
+	case err := <-listenErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server listen failed", "addr", config.Listen, "error", err)
+			stop()
+			<-snapshotDone
+			return exitErr
+		}
+	case <-ctx.Done():
+	}
 
 	slog.Info("start server shutdown")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeouts.Shutdown)
 
 	defer cancel()
 
@@ -67,11 +253,13 @@ func run() int {
 		return exitErr
 	}
 
+	<-snapshotDone
+
 	slog.Info("done server shutdown")
 
 	return exitOk
 }
 
 func main() {
-	os.Exit(run())
+	os.Exit(runCommand(os.Args[1:]))
 }
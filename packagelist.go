@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PackageList is the merged content of the package list: the entries and the provider profiles they reference.
+type PackageList struct {
+	Providers map[string]ProviderProfile `yaml:"providers"`
+	Packages  []PackageListEntry         `yaml:"packages"`
+}
+
+// packageListDocument is a file of the package list: either a plain sequence of entries, or a mapping with
+// the entries under packages:, the provider profiles under providers: and globs of more files under include:.
+type packageListDocument struct {
+	Include   []string                   `yaml:"include"`
+	Providers map[string]ProviderProfile `yaml:"providers"`
+	Packages  []PackageListEntry         `yaml:"packages"`
+}
+
+var (
+	// packageListKeys are the keys an entry of the package list may have.
+	packageListKeys     = yamlKeys(reflect.TypeOf(PackageListEntry{}))
+	packageListRootKeys = yamlKeys(reflect.TypeOf(packageListDocument{}))
+	providerProfileKeys = yamlKeys(reflect.TypeOf(ProviderProfile{}))
+	httpSettingsKeys    = yamlKeys(reflect.TypeOf(HTTPSettings{}))
+)
+
+func yamlKeys(t reflect.Type) map[string]bool {
+	keys := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(t.Field(i).Name)
+		}
+		keys[name] = true
+	}
+
+	return keys
+}
+
+// nodeRef is a node of one of the files of the package list.
+type nodeRef struct {
+	path string
+	node *yaml.Node
+}
+
+// sourceOf returns the value of key in the last of refs setting it, or the first of refs when none does.
+func sourceOf(refs []nodeRef, key string) nodeRef {
+	for j := len(refs) - 1; j >= 0; j-- {
+		if value := valueAt(refs[j].node, key); value != refs[j].node {
+			return nodeRef{path: refs[j].path, node: value}
+		}
+	}
+
+	return refs[0]
+}
+
+// parsedPackageList is a merged package list that remembers where each entry and profile is defined, then
+// overlaid.
+type parsedPackageList struct {
+	providers    map[string]ProviderProfile
+	providerRefs map[string][]nodeRef
+	entries      []PackageListEntry
+	entryRefs    [][]nodeRef
+}
+
+// PackageList returns the parsed package list.
+func (p parsedPackageList) PackageList() PackageList {
+	return PackageList{
+		Providers: p.providers,
+		Packages:  p.entries,
+	}
+}
+
+// index returns the index of the entry with the id, or -1.
+func (p parsedPackageList) index(id string) int {
+	for i, entry := range p.entries {
+		if entry.Id == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// valueAt returns the value of key in the mapping node, or the node itself when the key is missing.
+func valueAt(node *yaml.Node, key string) *yaml.Node {
+	for j := 0; j+1 < len(node.Content); j += 2 {
+		if node.Content[j].Value == key {
+			return node.Content[j+1]
+		}
+	}
+
+	return node
+}
+
+// at returns the diagnostic for the key of the i-th entry, or for the entry itself when the key is missing.
+func (p parsedPackageList) at(i int, key string, severity Severity, format string, args ...any) Diagnostic {
+	ref := sourceOf(p.entryRefs[i], key)
+
+	return newDiagnostic(ref.path, ref.node, severity, fmt.Sprintf(format, args...))
+}
+
+// providerAt is at for the key of the named provider profile.
+func (p parsedPackageList) providerAt(name string, key string, severity Severity, format string, args ...any) Diagnostic {
+	ref := sourceOf(p.providerRefs[name], key)
+
+	return newDiagnostic(ref.path, ref.node, severity, fmt.Sprintf(format, args...))
+}
+
+// checkKeys reports the keys of the mapping node that are not in keys.
+func checkKeys(path string, node *yaml.Node, keys map[string]bool) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for j := 0; j+1 < len(node.Content); j += 2 {
+		if key := node.Content[j]; !keys[key.Value] {
+			diagnostics = append(diagnostics, newDiagnostic(path, key, SeverityError, fmt.Sprintf("unknown key %q", key.Value)))
+		}
+	}
+
+	return diagnostics
+}
+
+// decodeNode decodes the node into out, reporting type errors at the line they occur.
+func decodeNode(path string, node *yaml.Node, out any) []Diagnostic {
+	var typeErr *yaml.TypeError
+	if err := node.Decode(out); errors.As(err, &typeErr) {
+		diagnostics := []Diagnostic{}
+		for _, message := range typeErr.Errors {
+			d := newDiagnostic(path, node, SeverityError, message)
+			// messages look like "line 3: cannot unmarshal ..."
+			if _, err := fmt.Sscanf(message, "line %d:", &d.Line); err == nil {
+				for j := 1; j < len(node.Content); j += 2 {
+					if node.Content[j].Line == d.Line {
+						d.Column = node.Content[j].Column
+					}
+				}
+				d.Message = strings.TrimSpace(message[strings.Index(message, ":")+1:])
+			}
+			diagnostics = append(diagnostics, d)
+		}
+		return diagnostics
+	} else if err != nil {
+		return []Diagnostic{newDiagnostic(path, node, SeverityError, err.Error())}
+	}
+
+	return nil
+}
+
+// packageListLoader merges the files of a package list, reporting entries and keys that cannot be decoded
+// as diagnostics.
+type packageListLoader struct {
+	parsed      parsedPackageList
+	diagnostics []Diagnostic
+	// loaded are the absolute paths of the files already merged, so that a file included twice is merged once.
+	loaded map[string]bool
+	// root confines the files of a remote package list to its local copy; it is empty for a local list.
+	root string
+}
+
+// parsePackageList loads the package list at path, a file or a directory of files, then applies the overlays
+// in order. The entries are not resolved against their provider profiles yet. The error is only set when a file
+// cannot be read or parsed at all. A package list fetched from a remote source may only load files below root,
+// the directory of its local copy, symbolic links included; the overlays are local and are not confined.
+func parsePackageList(path string, overlays []string, root string) (parsedPackageList, []Diagnostic, error) {
+	l := &packageListLoader{
+		parsed: parsedPackageList{
+			providers:    map[string]ProviderProfile{},
+			providerRefs: map[string][]nodeRef{},
+		},
+		diagnostics: []Diagnostic{},
+		loaded:      map[string]bool{},
+		root:        root,
+	}
+
+	if err := l.loadPath(path, false); err != nil {
+		return l.parsed, nil, err
+	}
+
+	l.root = ""
+	for _, overlay := range overlays {
+		if err := l.loadPath(overlay, true); err != nil {
+			return l.parsed, nil, err
+		}
+	}
+
+	return l.parsed, l.diagnostics, nil
+}
+
+func isPackageListFile(name string) bool {
+	_, ok := packageListExtensions[strings.ToLower(filepath.Ext(name))]
+
+	return ok
+}
+
+// loadPath merges the file at path, or the package list files of the directory at path in name order.
+func (l *packageListLoader) loadPath(path string, overlay bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return l.loadFile(path, overlay)
+	}
+
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !isPackageListFile(file.Name()) {
+			continue
+		}
+
+		if err := l.loadFile(filepath.Join(path, file.Name()), overlay); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadFile merges a file of the package list. Entries and profiles of an overlay must already exist, and
+// only replace the fields they set.
+func (l *packageListLoader) loadFile(path string, overlay bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+
+	if len(l.root) != 0 && !withinRoot(l.root, path) {
+		return fmt.Errorf("%s: outside of the remote package list", path)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	document, err := decodeDocument(path, contents)
+	if err != nil || document == nil {
+		return err
+	}
+
+	if document.Kind != yaml.MappingNode {
+		l.loadEntries(path, document, overlay)
+		return nil
+	}
+
+	l.diagnostics = append(l.diagnostics, checkKeys(path, document, packageListRootKeys)...)
+
+	if providers := valueAt(document, "providers"); providers != document {
+		l.loadProviders(path, providers, overlay)
+	}
+
+	if packages := valueAt(document, "packages"); packages != document {
+		l.loadEntries(path, packages, overlay)
+	}
+
+	// included files are merged after the file including them
+	if include := valueAt(document, "include"); include != document {
+		return l.include(path, include, overlay)
+	}
+
+	return nil
+}
+
+// include merges the files matching the globs of the include node, relative to the file including them.
+func (l *packageListLoader) include(path string, include *yaml.Node, overlay bool) error {
+	if include.Kind != yaml.SequenceNode {
+		l.diagnostics = append(l.diagnostics, newDiagnostic(path, include, SeverityError, "include must be a sequence of globs"))
+		return nil
+	}
+
+	for _, pattern := range include.Content {
+		glob := pattern.Value
+		if !filepath.IsAbs(glob) {
+			glob = filepath.Join(filepath.Dir(path), glob)
+		}
+
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, pattern, SeverityError, fmt.Sprintf("invalid include glob %q: %s", pattern.Value, err)))
+			continue
+		}
+
+		if len(matches) == 0 {
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, pattern, SeverityWarning, fmt.Sprintf("include %q matches no file", pattern.Value)))
+		}
+
+		for _, match := range matches {
+			if err := l.loadPath(match, overlay); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *packageListLoader) loadEntries(path string, sequence *yaml.Node, overlay bool) {
+	if sequence.Kind != yaml.SequenceNode {
+		l.diagnostics = append(l.diagnostics, newDiagnostic(path, sequence, SeverityError, "packages must be a sequence of entries"))
+		return
+	}
+
+	for _, node := range sequence.Content {
+		if node.Kind != yaml.MappingNode {
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, node, SeverityError, "entry must be a mapping"))
+			continue
+		}
+
+		l.diagnostics = append(l.diagnostics, checkKeys(path, node, packageListKeys)...)
+		ref := nodeRef{path: path, node: node}
+
+		if overlay {
+			id := valueAt(node, "id")
+			i := l.parsed.index(id.Value)
+			if id == node || i < 0 {
+				l.diagnostics = append(l.diagnostics, newDiagnostic(path, id, SeverityError, fmt.Sprintf("overlay of unknown package %q", id.Value)))
+				continue
+			}
+
+			// decoding over the entry only replaces the fields the overlay sets
+			l.diagnostics = append(l.diagnostics, decodeNode(path, node, &l.parsed.entries[i])...)
+			l.parsed.entryRefs[i] = append(l.parsed.entryRefs[i], ref)
+			continue
+		}
+
+		// a partially decoded entry is still linted so that every problem is reported at once
+		var entry PackageListEntry
+		l.diagnostics = append(l.diagnostics, decodeNode(path, node, &entry)...)
+
+		l.parsed.entries = append(l.parsed.entries, entry)
+		l.parsed.entryRefs = append(l.parsed.entryRefs, []nodeRef{ref})
+	}
+}
+
+func (l *packageListLoader) loadProviders(path string, providers *yaml.Node, overlay bool) {
+	if providers.Kind != yaml.MappingNode {
+		l.diagnostics = append(l.diagnostics, newDiagnostic(path, providers, SeverityError, "providers must be a mapping of names to profiles"))
+		return
+	}
+
+	for j := 0; j+1 < len(providers.Content); j += 2 {
+		key, node := providers.Content[j], providers.Content[j+1]
+		if node.Kind != yaml.MappingNode {
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, node, SeverityError, "provider profile must be a mapping"))
+			continue
+		}
+
+		l.diagnostics = append(l.diagnostics, checkKeys(path, node, providerProfileKeys)...)
+		if http := valueAt(node, "http"); http != node && http.Kind == yaml.MappingNode {
+			l.diagnostics = append(l.diagnostics, checkKeys(path, http, httpSettingsKeys)...)
+		}
+
+		profile, ok := l.parsed.providers[key.Value]
+		switch {
+		case overlay && !ok:
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, key, SeverityError, fmt.Sprintf("overlay of unknown provider %q", key.Value)))
+			continue
+		case !overlay && ok:
+			first := l.parsed.providerRefs[key.Value][0]
+			l.diagnostics = append(l.diagnostics, newDiagnostic(path, key, SeverityError, fmt.Sprintf("duplicate provider %s, first defined at %s:%d", key.Value, first.path, first.node.Line)))
+			continue
+		}
+
+		l.diagnostics = append(l.diagnostics, decodeNode(path, node, &profile)...)
+
+		l.parsed.providers[key.Value] = profile
+		l.parsed.providerRefs[key.Value] = append(l.parsed.providerRefs[key.Value], nodeRef{path: path, node: node})
+	}
+}
+
+// resolveEntry fills the type of the entry, and the endpoint and token it leaves empty, from the provider
+// profile it names. Entries naming no known profile are left as is.
+func resolveEntry(entry PackageListEntry, profiles map[string]ProviderProfile) PackageListEntry {
+	profile, ok := withBuiltinProviderProfiles(profiles)[entry.Provider]
+	if !ok {
+		return entry
+	}
+
+	entry.Type = profile.Type
+	if len(entry.Endpoint) == 0 {
+		entry.Endpoint = profile.Endpoint
+	}
+	if len(entry.Token) == 0 {
+		entry.Token = profile.Token
+	}
+
+	return entry
+}
+
+// LoadPackageList reads and lints the package list and its overlays, and resolves its secret references with
+// resolver, failing when any error is found. root is the local copy of a remote package list, see parsePackageList.
+func LoadPackageList(path string, overlays []string, root string, resolver *SecretResolver) (PackageList, error) {
+	p, diagnostics, err := LintPackageList(path, overlays, root)
+	if err != nil {
+		return PackageList{}, err
+	}
+
+	if err := diagnosticsError(diagnostics); err != nil {
+		return PackageList{}, err
+	}
+
+	if err := diagnosticsError(resolveSecrets(&p, resolver, root)); err != nil {
+		return PackageList{}, err
+	}
+
+	return p.PackageList(), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPackageListMergesFilesAndOverlays(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "list", "base.yaml"): `
+include:
+  - ../teams/*
+  - ../teams/b.json
+providers:
+  corp:
+    type: github
+    endpoint: https://ghe.example.com/api/v3
+packages:
+  - provider: corp
+    id: A.B
+    name: b
+    publisher: a
+    installer_type: zip-portable
+`,
+		filepath.Join(dir, "list", "c.toml"): `
+[[packages]]
+provider = "github"
+id = "C.D"
+name = "d"
+publisher = "c"
+installer_type = "zip-portable"
+`,
+		filepath.Join(dir, "list", "notes.txt"): "not a package list",
+		filepath.Join(dir, "teams", "a.yaml"): `
+- provider: corp
+  id: E.F
+  name: f
+  publisher: e
+  installer_type: zip-portable
+`,
+		filepath.Join(dir, "teams", "b.json"): `[{"provider": "github", "id": "G.H", "name": "h", "publisher": "g", "installer_type": "zip-portable"}]`,
+		filepath.Join(dir, "staging.yaml"): `
+providers:
+  corp:
+    endpoint: https://ghe-staging.example.com/api/v3
+packages:
+  - id: C.D
+    max_releases: 5
+`,
+	})
+
+	packageList, err := LoadPackageList(filepath.Join(dir, "list"), []string{filepath.Join(dir, "staging.yaml")}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files of a directory in name order, included files after the file including them, each file once
+	ids := []string{}
+	for _, entry := range packageList.Packages {
+		ids = append(ids, entry.Id)
+	}
+	if got := strings.Join(ids, " "); got != "A.B E.F G.H C.D" {
+		t.Fatalf("entries %s, want A.B E.F G.H C.D", got)
+	}
+
+	// the overlay replaces the fields it sets and keeps the others
+	if profile := packageList.Providers["corp"]; profile.Type != "github" || profile.Endpoint != "https://ghe-staging.example.com/api/v3" {
+		t.Errorf("profile corp = %+v, want the endpoint of the overlay", profile)
+	}
+	if entry := packageList.Packages[3]; entry.MaxReleases != 5 || entry.Name != "d" {
+		t.Errorf("entry C.D = %+v, want max_releases of the overlay and its own name", entry)
+	}
+
+	// entries are resolved against the profiles once the overlays are applied
+	for _, entry := range packageList.Packages[:2] {
+		if entry.Type != "github" || entry.Endpoint != "https://ghe-staging.example.com/api/v3" {
+			t.Errorf("entry %s resolved to type %q and endpoint %q", entry.Id, entry.Type, entry.Endpoint)
+		}
+	}
+}
+
+func TestLintPackageListReportsMergeConflicts(t *testing.T) {
+	dir := t.TempDir()
+	entry := "  - provider: github\n    id: %s\n    name: b\n    publisher: a\n    installer_type: zip-portable\n"
+
+	writeFiles(t, map[string]string{
+		filepath.Join(dir, "list", "a.yaml"): "providers:\n  corp:\n    type: github\npackages:\n" + strings.Replace(entry, "%s", "A.B", 1),
+		filepath.Join(dir, "list", "b.yaml"): "providers:\n  corp:\n    type: gitlab\npackages:\n" + strings.Replace(entry, "%s", "A.B", 1),
+		filepath.Join(dir, "overlay.yaml"):   "providers:\n  other:\n    endpoint: https://example.com\npackages:\n  - id: X.Y\n    max_releases: 1\n",
+	})
+
+	_, diagnostics, err := LintPackageList(filepath.Join(dir, "list"), []string{filepath.Join(dir, "overlay.yaml")}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := filepath.Join(dir, "list", "b.yaml")
+	overlay := filepath.Join(dir, "overlay.yaml")
+	want := []string{
+		b + ":2:3: error: duplicate provider corp, first defined at " + filepath.Join(dir, "list", "a.yaml") + ":3",
+		b + ":6:9: error: duplicate id A.B, first defined at " + filepath.Join(dir, "list", "a.yaml") + ":5",
+		overlay + `:2:3: error: overlay of unknown provider "other"`,
+		overlay + `:5:9: error: overlay of unknown package "X.Y"`,
+	}
+
+	got := []string{}
+	for _, diagnostic := range diagnostics {
+		got = append(got, diagnostic.String())
+	}
+
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("diagnostics\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limit exhausted")
+
+type githubRateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+func (l githubRateLimit) exhausted() bool {
+	return l.remaining <= 0 && time.Now().Before(l.reset)
+}
+
+// GithubTokenPool tracks the X-RateLimit-* headers of every token per host and hands out the tokens
+// of entries without their own one in rotation, skipping those whose quota is used up until they reset.
+// The pooled tokens are only handed out for the host they belong to, never to other endpoints of the list.
+type GithubTokenPool struct {
+	host   string
+	tokens []string
+
+	mu     sync.Mutex
+	next   int
+	limits map[string]githubRateLimit
+}
+
+func NewGithubTokenPool(host string, tokens []string) *GithubTokenPool {
+	return &GithubTokenPool{
+		host:   host,
+		tokens: tokens,
+		limits: map[string]githubRateLimit{},
+	}
+}
+
+func rateLimitKey(host string, token string) string {
+	return host + "\x00" + token
+}
+
+// Pick returns the token to use against host. A non-empty preferred token is always used as is,
+// as long as its quota is not exhausted. ErrRateLimited is returned when no usable token is left.
+func (p *GithubTokenPool) Pick(host string, preferred string) (string, error) {
+	if p == nil {
+		return preferred, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rotate := len(preferred) == 0 && len(p.tokens) != 0 && strings.EqualFold(host, p.host)
+
+	candidates := []string{preferred}
+	start := 0
+	if rotate {
+		candidates = p.tokens
+		start = p.next
+	}
+
+	var reset time.Time
+	for i := 0; i < len(candidates); i++ {
+		token := candidates[(start+i)%len(candidates)]
+
+		limit, ok := p.limits[rateLimitKey(host, token)]
+		if ok && limit.exhausted() {
+			if reset.IsZero() || limit.reset.Before(reset) {
+				reset = limit.reset
+			}
+			continue
+		}
+
+		if rotate {
+			p.next = (start + i + 1) % len(candidates)
+		}
+
+		return token, nil
+	}
+
+	return "", fmt.Errorf("%s: %w until %s", host, ErrRateLimited, reset.Format(time.RFC3339))
+}
+
+// Observe records the rate limit reported by a response obtained with token.
+func (p *GithubTokenPool) Observe(host string, token string, header http.Header) {
+	if p == nil {
+		return
+	}
+
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	limit := githubRateLimit{
+		remaining: remaining,
+		reset:     time.Unix(reset, 0),
+	}
+
+	if limit.exhausted() {
+		slog.Warn("github rate limit exhausted", "host", host, "reset", limit.reset)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limits[rateLimitKey(host, token)] = limit
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGithubTokenPoolOnlyRotatesForItsHost(t *testing.T) {
+	pool := NewGithubTokenPool("api.github.com", []string{"a", "b"})
+
+	for _, want := range []string{"a", "b", "a"} {
+		if got, err := pool.Pick("api.github.com", ""); err != nil || got != want {
+			t.Errorf("Pick(api.github.com) = %q, %v, want %q", got, err, want)
+		}
+	}
+
+	if got, err := pool.Pick("ghe.example.com", ""); err != nil || got != "" {
+		t.Errorf("Pick(ghe.example.com) = %q, %v, want no token", got, err)
+	}
+
+	if got, err := pool.Pick("ghe.example.com", "own"); err != nil || got != "own" {
+		t.Errorf("Pick(ghe.example.com, own) = %q, %v, want the entry token", got, err)
+	}
+}
+
+func TestGithubTokenPoolSkipsExhaustedTokens(t *testing.T) {
+	pool := NewGithubTokenPool("api.github.com", []string{"a", "b"})
+
+	exhausted := http.Header{}
+	exhausted.Set("X-RateLimit-Remaining", "0")
+	exhausted.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+
+	pool.Observe("api.github.com", "a", exhausted)
+	for i := 0; i < 2; i++ {
+		if got, _ := pool.Pick("api.github.com", ""); got != "b" {
+			t.Errorf("Pick = %q, want b", got)
+		}
+	}
+
+	pool.Observe("api.github.com", "b", exhausted)
+	if _, err := pool.Pick("api.github.com", ""); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Pick error = %v, want ErrRateLimited", err)
+	}
+}
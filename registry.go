@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// providerTypes are the types a provider profile may have.
+var providerTypes = []string{"github", "gitlab"}
+
+// ProviderProfile is a named provider instance declared under providers: in the package list. Entries
+// referencing it by name inherit its endpoint and token unless they set their own.
+type ProviderProfile struct {
+	Type     string       `yaml:"type"`
+	Endpoint string       `yaml:"endpoint"`
+	Token    string       `yaml:"token"`
+	HTTP     HTTPSettings `yaml:"http"`
+}
+
+// host returns the host the profile sends its requests to, or "" when it has no valid endpoint.
+func (p ProviderProfile) host() string {
+	endpoint := p.Endpoint
+	if len(endpoint) == 0 && p.Type == "github" {
+		endpoint = githubDefaultEndpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+type HTTPSettings struct {
+	// Timeout overrides the timeout configured for the provider type.
+	Timeout time.Duration `yaml:"timeout"`
+	// Proxy is the URL of the proxy upstream requests go through instead of the environment's.
+	Proxy string `yaml:"proxy"`
+	// InsecureSkipVerify disables TLS certificate verification, for instances with self-signed certificates.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	// Headers are added to every upstream request.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// builtinProviderProfiles keep package lists without providers: working, and may be overridden by name.
+var builtinProviderProfiles = map[string]ProviderProfile{
+	"github": {Type: "github"},
+	"gitlab": {Type: "gitlab"},
+}
+
+// withBuiltinProviderProfiles returns profiles together with the builtin profiles they do not override.
+func withBuiltinProviderProfiles(profiles map[string]ProviderProfile) map[string]ProviderProfile {
+	all := map[string]ProviderProfile{}
+	for name, profile := range builtinProviderProfiles {
+		all[name] = profile
+	}
+	for name, profile := range profiles {
+		all[name] = profile
+	}
+
+	return all
+}
+
+func providerProfileNames(profiles map[string]ProviderProfile) []string {
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ProviderFactory creates the provider of a profile.
+type ProviderFactory func(profile ProviderProfile) (PackageProvider, error)
+
+type registeredProvider struct {
+	profile  ProviderProfile
+	provider PackageProvider
+}
+
+// ProviderRegistry is the PackageProvider that delegates to the provider instance named by the entry.
+type ProviderRegistry struct {
+	factories map[string]ProviderFactory
+
+	mu        sync.Mutex
+	providers atomic.Pointer[map[string]registeredProvider]
+}
+
+// NewProviderRegistry creates a registry building providers with the factory of their profile type.
+// Only the builtin profiles are registered until Configure is called.
+func NewProviderRegistry(factories map[string]ProviderFactory) (*ProviderRegistry, error) {
+	r := &ProviderRegistry{
+		factories: factories,
+	}
+	r.providers.Store(&map[string]registeredProvider{})
+
+	if err := r.Configure(nil); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Configure replaces the registered profiles. Providers whose profile did not change are kept, so their
+// connections and state survive a reload.
+func (r *ProviderRegistry) Configure(profiles map[string]ProviderProfile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := *r.providers.Load()
+	next := map[string]registeredProvider{}
+
+	for name, profile := range withBuiltinProviderProfiles(profiles) {
+		if existing, ok := current[name]; ok && reflect.DeepEqual(existing.profile, profile) {
+			next[name] = existing
+			continue
+		}
+
+		factory, ok := r.factories[profile.Type]
+		if !ok {
+			return fmt.Errorf("provider %s: unknown type %q", name, profile.Type)
+		}
+
+		provider, err := factory(profile)
+		if err != nil {
+			return fmt.Errorf("provider %s: %w", name, err)
+		}
+
+		next[name] = registeredProvider{profile: profile, provider: provider}
+	}
+
+	r.providers.Store(&next)
+
+	return nil
+}
+
+// FetchVersions implements PackageProvider.
+func (r *ProviderRegistry) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	registered, ok := (*r.providers.Load())[entry.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown package provider: %s", entry.Provider)
+	}
+
+	return registered.provider.FetchVersions(ctx, entry)
+}
+
+// Retain implements entryRetainer.
+func (r *ProviderRegistry) Retain(packageList []PackageListEntry) {
+	for _, registered := range *r.providers.Load() {
+		retainEntries(registered.provider, packageList)
+	}
+}
+
+var _ PackageProvider = &ProviderRegistry{}
+
+// HeaderTransport adds fixed headers to the requests sent to a single host. Requests to other hosts, such as
+// redirect targets or asset links, go without them since the headers may hold secrets.
+type HeaderTransport struct {
+	next    http.RoundTripper
+	host    string
+	headers map[string]string
+}
+
+func NewHeaderTransport(next http.RoundTripper, host string, headers map[string]string) *HeaderTransport {
+	return &HeaderTransport{
+		next:    next,
+		host:    host,
+		headers: headers,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.host) == 0 || !strings.EqualFold(req.URL.Host, t.host) {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	return t.next.RoundTrip(req)
+}
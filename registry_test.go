@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestHeaderTransportOnlySendsHeadersToTheEndpointHost(t *testing.T) {
+	received := map[string]string{}
+
+	outside := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["outside"] = r.Header.Get("X-Secret")
+	}))
+	defer outside.Close()
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received["endpoint"] = r.Header.Get("X-Secret")
+		http.Redirect(w, r, outside.URL, http.StatusFound)
+	}))
+	defer endpoint.Close()
+
+	u, _ := url.Parse(endpoint.URL)
+	client := &http.Client{Transport: NewHeaderTransport(http.DefaultTransport, u.Host, map[string]string{"X-Secret": "secret"})}
+
+	res, err := client.Get(endpoint.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if received["endpoint"] != "secret" {
+		t.Errorf("endpoint received %q, want the header", received["endpoint"])
+	}
+	if received["outside"] != "" {
+		t.Errorf("redirect target received %q, want no header", received["outside"])
+	}
+}
+
+func TestProviderProfileHost(t *testing.T) {
+	tests := []struct {
+		profile ProviderProfile
+		want    string
+	}{
+		{ProviderProfile{Type: "github"}, "api.github.com"},
+		{ProviderProfile{Type: "github", Endpoint: "https://ghe.example.com/api/v3"}, "ghe.example.com"},
+		{ProviderProfile{Type: "gitlab", Endpoint: "https://gitlab.example.com:8443"}, "gitlab.example.com:8443"},
+		{ProviderProfile{Type: "gitlab"}, ""},
+	}
+
+	for _, test := range tests {
+		if got := test.profile.host(); got != test.want {
+			t.Errorf("host of %+v = %q, want %q", test.profile, got, test.want)
+		}
+	}
+}
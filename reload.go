@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// PackageListReloader replaces the package list of the repository whenever its source changes or SIGHUP
+// is received. A list failing to load or validate is rejected and the previous one stays in service.
+type PackageListReloader struct {
+	source     string
+	load       func(ctx context.Context) (PackageList, error)
+	repository WingetSrcRepository
+	registry   *ProviderRegistry
+	workers    int
+
+	digest   [sha256.Size]byte
+	rejected string
+}
+
+// NewPackageListReloader creates a reloader of the package list loaded by load from source, which is
+// currently loaded as packageList. Provider profiles of a reloaded list are applied to registry.
+func NewPackageListReloader(source string, load func(ctx context.Context) (PackageList, error), packageList PackageList, repository WingetSrcRepository, registry *ProviderRegistry, workers int) *PackageListReloader {
+	return &PackageListReloader{
+		source:     source,
+		load:       load,
+		repository: repository,
+		registry:   registry,
+		workers:    workers,
+		digest:     packageListDigest(packageList),
+	}
+}
+
+func packageListDigest(packageList PackageList) [sha256.Size]byte {
+	return sha256.Sum256([]byte(fmt.Sprintf("%#v", packageList)))
+}
+
+// Reload loads the package list and swaps it in if it changed, then refreshes added and edited entries.
+func (r *PackageListReloader) Reload(ctx context.Context) error {
+	packageList, err := r.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	digest := packageListDigest(packageList)
+	if digest == r.digest {
+		return nil
+	}
+
+	previous := map[string]string{}
+	for _, entry := range r.repository.PackageList() {
+		previous[entry.Id] = cacheKey(entry)
+	}
+
+	// profiles are applied first so that added entries find the providers they name
+	if err := r.registry.Configure(packageList.Providers); err != nil {
+		return err
+	}
+
+	r.repository.SetPackageList(packageList.Packages)
+	r.digest = digest
+
+	changed := []PackageListEntry{}
+	for _, entry := range packageList.Packages {
+		if previous[entry.Id] != cacheKey(entry) {
+			changed = append(changed, entry)
+		}
+	}
+
+	slog.Info("package list reloaded", "source", r.source, "entries", len(packageList.Packages), "providers", len(packageList.Providers), "changed", len(changed))
+
+	parallel(len(changed), r.workers, func(i int) {
+		if _, err := r.repository.Refresh(ctx, changed[i]); err != nil {
+			slog.Warn("refresh of reloaded entry failed", "id", changed[i].Id, "error", err)
+		}
+	})
+
+	return nil
+}
+
+// Run polls the package list every interval and reloads it on SIGHUP until ctx is done.
+func (r *PackageListReloader) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading package list", "source", r.source)
+		case <-ticker.C:
+		}
+
+		if err := r.Reload(ctx); err != nil {
+			// a broken file is reported once, not on every poll until it is fixed
+			if err.Error() != r.rejected {
+				slog.Error("package list reload rejected, keeping the previous list", "source", r.source, "error", err)
+			}
+			r.rejected = err.Error()
+			continue
+		}
+		r.rejected = ""
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fetchRecorder is a provider recording the entries it fetches.
+type fetchRecorder struct {
+	mu      sync.Mutex
+	fetched []string
+}
+
+func (f *fetchRecorder) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fetched = append(f.fetched, entry.Id)
+
+	return []Version{{Version: "1.0.0"}}, nil
+}
+
+// take returns the entries fetched since the last call, sorted.
+func (f *fetchRecorder) take() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fetched := f.fetched
+	f.fetched = nil
+	sort.Strings(fetched)
+
+	return strings.Join(fetched, " ")
+}
+
+func TestPackageListReloader(t *testing.T) {
+	recorder := &fetchRecorder{}
+	factory := func(profile ProviderProfile) (PackageProvider, error) {
+		return recorder, nil
+	}
+	registry, err := NewProviderRegistry(map[string]ProviderFactory{"github": factory, "gitlab": factory})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kept := PackageListEntry{Provider: "github", Id: "Kept.Package"}
+	edited := PackageListEntry{Provider: "github", Id: "Edited.Package"}
+	removed := PackageListEntry{Provider: "github", Id: "Removed.Package"}
+	current := PackageList{Packages: []PackageListEntry{kept, edited, removed}}
+
+	index := NewManifestIndex()
+	repository := NewWingetSrcRepository(current.Packages, registry, index, 1)
+	for _, entry := range current.Packages {
+		if _, err := repository.Refresh(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorder.take()
+
+	var loaded PackageList
+	var loadErr error
+	reloader := NewPackageListReloader("list.yaml", func(ctx context.Context) (PackageList, error) {
+		return loaded, loadErr
+	}, current, repository, registry, 1)
+
+	ids := func() string {
+		ids := []string{}
+		for _, entry := range repository.PackageList() {
+			ids = append(ids, entry.Id)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	// an unchanged list is not swapped in again nor refreshed
+	loaded = current
+	if err := reloader.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fetched := recorder.take(); len(fetched) != 0 {
+		t.Errorf("unchanged list fetched %s", fetched)
+	}
+
+	// a list failing to load or naming an unknown provider type is rejected and the previous one stays
+	loaded, loadErr = PackageList{}, errors.New("list.yaml:1:1: error: id is required")
+	if err := reloader.Reload(context.Background()); err == nil {
+		t.Error("list failing to load was not rejected")
+	}
+
+	loaded, loadErr = PackageList{
+		Providers: map[string]ProviderProfile{"corp": {Type: "bitbucket"}},
+		Packages:  []PackageListEntry{kept},
+	}, nil
+	if err := reloader.Reload(context.Background()); err == nil {
+		t.Error("list with an unknown provider type was not rejected")
+	}
+
+	if got := ids(); got != "Kept.Package Edited.Package Removed.Package" {
+		t.Errorf("package list after rejected reloads = %s", got)
+	}
+	if fetched := recorder.take(); len(fetched) != 0 {
+		t.Errorf("rejected reloads fetched %s", fetched)
+	}
+
+	// only added and edited entries are refreshed, removed ones leave the index
+	edited.MaxReleases = 10
+	loaded = PackageList{Packages: []PackageListEntry{kept, edited, {Provider: "github", Id: "Added.Package"}}}
+	if err := reloader.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ids(); got != "Kept.Package Edited.Package Added.Package" {
+		t.Errorf("package list after reload = %s", got)
+	}
+	if fetched := recorder.take(); fetched != "Added.Package Edited.Package" {
+		t.Errorf("reload fetched %q, want Added.Package Edited.Package", fetched)
+	}
+	if _, ok := index.Get("Removed.Package"); ok {
+		t.Error("removed entry is still indexed")
+	}
+	if _, ok := index.Get("Added.Package"); !ok {
+		t.Error("added entry is not indexed")
+	}
+}
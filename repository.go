@@ -1,22 +1,57 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"net/url"
 	"strings"
-
-	"gopkg.in/yaml.v2"
+	"sync/atomic"
 )
 
 type QueryManifestConditon func(PackageListEntry) bool
 
 type WingetSrcRepository interface {
-	QueryManifest(condition QueryManifestConditon) ([]Manifest, error)
-	QueryPackageManifests(identifier string) (PackageManifests, error)
+	QueryManifest(ctx context.Context, condition QueryManifestConditon) ([]Manifest, error)
+	QueryPackageManifests(ctx context.Context, identifier string) (PackageManifests, error)
+	PackageList() []PackageListEntry
+	SetPackageList(packageList []PackageListEntry)
+	Refresh(ctx context.Context, entry PackageListEntry) (VersionDiff, error)
+	Invalidate(ctx context.Context, entry PackageListEntry) (VersionDiff, error)
 }
 
 type WingetSrcRepositoryImpl struct {
-	packageList []PackageListEntry
+	packageList *atomic.Pointer[[]PackageListEntry]
+	provider    PackageProvider
+	index       *ManifestIndex
+	workers     int
+}
+
+type EntryFailure struct {
+	Id  string
+	Err error
+}
+
+// PartialResultError is returned along with the manifests that could be resolved when some entries failed.
+type PartialResultError struct {
+	Failures []EntryFailure
+}
+
+func (e *PartialResultError) Error() string {
+	messages := []string{}
+	for _, failure := range e.Failures {
+		messages = append(messages, fmt.Sprintf("%s: %s", failure.Id, failure.Err))
+	}
+
+	return fmt.Sprintf("%d packages failed: %s", len(e.Failures), strings.Join(messages, "; "))
+}
+
+func (e *PartialResultError) Identifiers() []string {
+	ids := []string{}
+	for _, failure := range e.Failures {
+		ids = append(ids, failure.Id)
+	}
+
+	return ids
 }
 
 func ById(id string) QueryManifestConditon {
@@ -31,6 +66,40 @@ func ByName(name string) QueryManifestConditon {
 	}
 }
 
+// ByGithubRepository matches GitHub entries whose releases live at the repository API URL, e.g. "https://api.github.com/repos/owner/repo".
+func ByGithubRepository(apiUrl string) QueryManifestConditon {
+	return func(entry PackageListEntry) bool {
+		if entry.Type != "github" {
+			return false
+		}
+
+		owner, repo := Github{}.repository(entry)
+
+		return strings.EqualFold(apiUrl, fmt.Sprintf("%s/repos/%s/%s", Github{}.endpoint(entry), owner, repo))
+	}
+}
+
+// ByGitlabProject matches GitLab entries of the project id hosted on the instance of webUrl.
+func ByGitlabProject(webUrl string, projectID uint) QueryManifestConditon {
+	return func(entry PackageListEntry) bool {
+		if entry.Type != "gitlab" || entry.ProjectID != projectID {
+			return false
+		}
+
+		endpoint, err := url.Parse(entry.Endpoint)
+		if err != nil {
+			return false
+		}
+
+		project, err := url.Parse(webUrl)
+		if err != nil {
+			return false
+		}
+
+		return strings.EqualFold(endpoint.Host, project.Host)
+	}
+}
+
 func Or(conditions ...QueryManifestConditon) QueryManifestConditon {
 	return func(entry PackageListEntry) bool {
 		for _, condition := range conditions {
@@ -55,29 +124,37 @@ func And(conditions ...QueryManifestConditon) QueryManifestConditon {
 	}
 }
 
-func (w WingetSrcRepositoryImpl) QueryManifest(condition QueryManifestConditon) ([]Manifest, error) {
+func (w WingetSrcRepositoryImpl) QueryManifest(ctx context.Context, condition QueryManifestConditon) ([]Manifest, error) {
+	matched := []PackageListEntry{}
+	for _, entry := range w.PackageList() {
+		if condition(entry) {
+			matched = append(matched, entry)
+		}
+	}
+
+	resolved := make([]PackageManifests, len(matched))
+	errs := make([]error, len(matched))
+
+	parallel(len(matched), w.workers, func(i int) {
+		resolved[i], errs[i] = w.lookup(ctx, matched[i])
+	})
+
 	manifests := []Manifest{}
+	failures := []EntryFailure{}
 
-	for _, entry := range w.packageList {
-		if !condition(entry) {
+	for i, entry := range matched {
+		if errs[i] != nil {
+			failures = append(failures, EntryFailure{Id: entry.Id, Err: errs[i]})
 			continue
 		}
 
-		provider, err := dispatchProvider(entry)
-		if err != nil {
-			return nil, fmt.Errorf("unknown package provider")
-		}
-
-		versions, err := provider.FetchVersions(entry)
-		if err != nil {
-			return nil, fmt.Errorf("fetch versions: %w", err)
-		}
+		pkgManifests := resolved[i]
 
 		manifestVersions := []ManifestVersion{}
 
-		for _, version := range versions {
+		for _, version := range pkgManifests.Versions {
 			manifestVersions = append(manifestVersions, ManifestVersion{
-				PackageVersion: version.Version,
+				PackageVersion: version.PackageVersion,
 			})
 		}
 
@@ -89,12 +166,16 @@ func (w WingetSrcRepositoryImpl) QueryManifest(condition QueryManifestConditon)
 		})
 	}
 
+	if len(failures) != 0 {
+		return manifests, &PartialResultError{Failures: failures}
+	}
+
 	return manifests, nil
 }
 
-func (w WingetSrcRepositoryImpl) QueryPackageManifests(identifier string) (PackageManifests, error) {
+func (w WingetSrcRepositoryImpl) QueryPackageManifests(ctx context.Context, identifier string) (PackageManifests, error) {
 	var found PackageListEntry
-	for _, entry := range w.packageList {
+	for _, entry := range w.PackageList() {
 		if entry.Id == identifier {
 			found = entry
 			break
@@ -105,14 +186,33 @@ func (w WingetSrcRepositoryImpl) QueryPackageManifests(identifier string) (Packa
 		return PackageManifests{}, fmt.Errorf("unknown package identifier")
 	}
 
-	provider, err := dispatchProvider(found)
-	if err != nil {
-		return PackageManifests{}, fmt.Errorf("unknown package provider")
+	return w.lookup(ctx, found)
+}
+
+func (w WingetSrcRepositoryImpl) PackageList() []PackageListEntry {
+	return *w.packageList.Load()
+}
+
+// SetPackageList atomically replaces the package list and drops the manifests of removed entries, along with
+// what the provider kept for removed or edited entries.
+func (w WingetSrcRepositoryImpl) SetPackageList(packageList []PackageListEntry) {
+	w.packageList.Store(&packageList)
+
+	ids := []string{}
+	for _, entry := range packageList {
+		ids = append(ids, entry.Id)
 	}
 
-	versions, err := provider.FetchVersions(found)
+	w.index.Retain(ids)
+
+	retainEntries(w.provider, packageList)
+}
+
+// Refresh resolves the entry against its provider and replaces its indexed manifests.
+func (w WingetSrcRepositoryImpl) Refresh(ctx context.Context, entry PackageListEntry) (VersionDiff, error) {
+	versions, err := w.provider.FetchVersions(ctx, entry)
 	if err != nil {
-		return PackageManifests{}, fmt.Errorf("fetch versions: %w", err)
+		return VersionDiff{}, fmt.Errorf("fetch versions: %w", err)
 	}
 
 	pkgManifestVersions := []PackageManifestsVersion{}
@@ -122,44 +222,55 @@ func (w WingetSrcRepositoryImpl) QueryPackageManifests(identifier string) (Packa
 			PackageVersion: version.Version,
 			Installers:     version.Installers,
 			DefaultLocale: Locale{
-				PackageName:      found.Name,
+				PackageName:      entry.Name,
 				PackageLocale:    "en-us",
-				Publisher:        found.Publisher,
-				ShortDescription: found.Description,
+				Publisher:        entry.Publisher,
+				ShortDescription: entry.Description,
 			},
 		})
 	}
 
-	return PackageManifests{
-		PackageIdentifier: found.Id,
+	return w.index.Put(PackageManifests{
+		PackageIdentifier: entry.Id,
 		Versions:          pkgManifestVersions,
-	}, nil
+	}), nil
 }
 
-func dispatchProvider(entry PackageListEntry) (PackageProvider, error) {
-	switch entry.Provider {
-	case "github":
-		return Github{}, nil
-	case "gitlab":
-		return Gitlab{}, nil
-	default:
-		return nil, fmt.Errorf("unknown package provider")
+// Invalidate drops whatever the provider cached for the entry and refreshes it right away.
+func (w WingetSrcRepositoryImpl) Invalidate(ctx context.Context, entry PackageListEntry) (VersionDiff, error) {
+	if invalidator, ok := w.provider.(interface{ Invalidate(PackageListEntry) }); ok {
+		invalidator.Invalidate(entry)
 	}
+
+	return w.Refresh(ctx, entry)
 }
 
-func NewWingetSrcRepository(packageListPath string) (WingetSrcRepository, error) {
-	f, err := os.Open(packageListPath)
-	if err != nil {
-		return nil, err
+// lookup reads the entry from the index, resolving it once if it has not been indexed yet.
+func (w WingetSrcRepositoryImpl) lookup(ctx context.Context, entry PackageListEntry) (PackageManifests, error) {
+	if pkgManifests, ok := w.index.Get(entry.Id); ok {
+		return pkgManifests, nil
+	}
+
+	if _, err := w.Refresh(ctx, entry); err != nil {
+		return PackageManifests{}, err
 	}
-	defer f.Close()
 
-	var packageList []PackageListEntry
-	if err := yaml.NewDecoder(f).Decode(&packageList); err != nil {
-		return nil, err
+	pkgManifests, _ := w.index.Get(entry.Id)
+
+	return pkgManifests, nil
+}
+
+// NewWingetSrcRepository creates the repository serving packageList. Resolved manifests are kept in index,
+// and workers bounds how many entries are resolved concurrently.
+func NewWingetSrcRepository(packageList []PackageListEntry, provider PackageProvider, index *ManifestIndex, workers int) WingetSrcRepository {
+	w := WingetSrcRepositoryImpl{
+		packageList: &atomic.Pointer[[]PackageListEntry]{},
+		provider:    provider,
+		index:       index,
+		workers:     workers,
 	}
+	// the index may hold packages restored from a snapshot that are no longer listed
+	w.SetPackageList(packageList)
 
-	return WingetSrcRepositoryImpl{
-		packageList: packageList,
-	}, nil
+	return w
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RetryOptions struct {
+	// MaxRetries is how many times a failed request is repeated; zero disables retries.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry, doubled on every following one.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff and the accepted Retry-After.
+	MaxDelay time.Duration
+}
+
+// RetryTransport is a http.RoundTripper that repeats idempotent requests failing with a network error,
+// 429 or 5xx, waiting with exponential backoff and full jitter or as long as Retry-After asks.
+type RetryTransport struct {
+	next    http.RoundTripper
+	options RetryOptions
+}
+
+func NewRetryTransport(next http.RoundTripper, options RetryOptions) RetryTransport {
+	return RetryTransport{
+		next:    next,
+		options: options,
+	}
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as a HTTP date.
+func retryAfter(header string) (time.Duration, bool) {
+	if len(header) == 0 {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at), true
+	}
+
+	return 0, false
+}
+
+func (t RetryTransport) backoff(attempt int) time.Duration {
+	d := t.options.BaseDelay << attempt
+	if d <= 0 || d > t.options.MaxDelay {
+		d = t.options.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := t.next.RoundTrip(req)
+
+		if attempt >= t.options.MaxRetries || errors.Is(err, ErrCircuitOpen) || req.Context().Err() != nil {
+			return res, err
+		}
+
+		wait := t.backoff(attempt)
+
+		if err == nil {
+			if !retryableStatus(res.StatusCode) {
+				return res, nil
+			}
+
+			if d, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+				if d > t.options.MaxDelay {
+					return res, nil
+				}
+				wait = d
+			}
+
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+
+			slog.Warn("retry upstream request", "url", req.URL.Redacted(), "status", res.StatusCode, "attempt", attempt+1, "wait", wait)
+		} else {
+			slog.Warn("retry upstream request", "url", req.URL.Redacted(), "error", err, "attempt", attempt+1, "wait", wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+var _ http.RoundTripper = RetryTransport{}
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+type BreakerOptions struct {
+	// Threshold is the number of consecutive failures opening the circuit of a host; zero disables the breaker.
+	Threshold int
+	// Cooldown is how long an open circuit fails fast before a single trial request is let through.
+	Cooldown time.Duration
+}
+
+type circuit struct {
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// CircuitBreakerTransport is a http.RoundTripper failing fast with ErrCircuitOpen for hosts
+// whose recent requests kept failing with a network error or 5xx.
+type CircuitBreakerTransport struct {
+	next    http.RoundTripper
+	options BreakerOptions
+
+	mu       *sync.Mutex
+	circuits map[string]*circuit
+}
+
+func NewCircuitBreakerTransport(next http.RoundTripper, options BreakerOptions) CircuitBreakerTransport {
+	return CircuitBreakerTransport{
+		next:     next,
+		options:  options,
+		mu:       &sync.Mutex{},
+		circuits: map[string]*circuit{},
+	}
+}
+
+func (t CircuitBreakerTransport) allow(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.circuits[host]
+	if !ok || c.failures < t.options.Threshold {
+		return true
+	}
+
+	if c.trial || time.Since(c.openedAt) < t.options.Cooldown {
+		return false
+	}
+
+	c.trial = true
+
+	return true
+}
+
+func (t CircuitBreakerTransport) record(host string, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.circuits[host]
+	if !ok {
+		c = &circuit{}
+		t.circuits[host] = c
+	}
+
+	c.trial = false
+
+	if !failed {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= t.options.Threshold {
+		if c.failures == t.options.Threshold {
+			slog.Warn("circuit breaker opened", "host", host, "cooldown", t.options.Cooldown)
+		}
+		c.openedAt = time.Now()
+	}
+}
+
+// release ends a trial request without an outcome, so that the next request after it tries the host again.
+func (t CircuitBreakerTransport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c, ok := t.circuits[host]; ok {
+		c.trial = false
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t CircuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.options.Threshold <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	host := req.URL.Host
+	if !t.allow(host) {
+		return nil, fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+	}
+
+	res, err := t.next.RoundTrip(req)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller gave up, which says nothing about the host
+		t.release(host)
+	case err != nil:
+		t.record(host, true)
+	default:
+		t.record(host, res.StatusCode >= 500)
+	}
+
+	return res, err
+}
+
+var _ http.RoundTripper = CircuitBreakerTransport{}
+
+// FallbackProvider remembers the last versions successfully fetched for every entry and serves them
+// when the upstream fails, so an outage degrades to stale data instead of errors.
+type FallbackProvider struct {
+	provider PackageProvider
+
+	mu        *sync.Mutex
+	lastKnown map[string][]Version
+}
+
+func NewFallbackProvider(provider PackageProvider) FallbackProvider {
+	return FallbackProvider{
+		provider:  provider,
+		mu:        &sync.Mutex{},
+		lastKnown: map[string][]Version{},
+	}
+}
+
+// FetchVersions implements PackageProvider.
+func (f FallbackProvider) FetchVersions(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+	key := cacheKey(entry)
+
+	versions, err := f.provider.FetchVersions(ctx, entry)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		f.lastKnown[key] = versions
+		return versions, nil
+	}
+
+	if last, ok := f.lastKnown[key]; ok && ctx.Err() == nil {
+		slog.Warn("upstream failed, serve last known-good versions", "id", entry.Id, "error", err)
+		return last, nil
+	}
+
+	return nil, err
+}
+
+// Retain implements entryRetainer, forgetting the versions of the entries removed or edited since.
+func (f FallbackProvider) Retain(packageList []PackageListEntry) {
+	listed := map[string]bool{}
+	for _, entry := range packageList {
+		listed[cacheKey(entry)] = true
+	}
+
+	f.mu.Lock()
+	for key := range f.lastKnown {
+		if !listed[key] {
+			delete(f.lastKnown, key)
+		}
+	}
+	f.mu.Unlock()
+
+	retainEntries(f.provider, packageList)
+}
+
+var _ PackageProvider = FallbackProvider{}
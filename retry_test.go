@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubTransport answers requests with the statuses it is given in turn, a zero status being a network error.
+type stubTransport struct {
+	statuses []int
+	header   http.Header
+	calls    int
+}
+
+var errNetwork = errors.New("connection refused")
+
+func (s *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := s.statuses[len(s.statuses)-1]
+	if s.calls < len(s.statuses) {
+		status = s.statuses[s.calls]
+	}
+	s.calls++
+
+	if status == 0 {
+		return nil, errNetwork
+	}
+
+	return &http.Response{StatusCode: status, Header: s.header, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		header   http.Header
+		status   int
+		calls    int
+	}{
+		{name: "success", statuses: []int{200}, status: 200, calls: 1},
+		{name: "server errors", statuses: []int{503, 500, 200}, status: 200, calls: 3},
+		{name: "network error", statuses: []int{0, 200}, status: 200, calls: 2},
+		{name: "too many requests", statuses: []int{429, 200}, status: 200, calls: 2},
+		{name: "client error", statuses: []int{404}, status: 404, calls: 1},
+		{name: "gives up", statuses: []int{502}, status: 502, calls: 4},
+		{name: "not idempotent", method: http.MethodPost, statuses: []int{503, 200}, status: 503, calls: 1},
+		{name: "short retry after", statuses: []int{429, 200}, header: http.Header{"Retry-After": {"0"}}, status: 200, calls: 2},
+		{name: "long retry after", statuses: []int{429, 200}, header: http.Header{"Retry-After": {"3600"}}, status: 429, calls: 1},
+	}
+
+	for _, test := range tests {
+		stub := &stubTransport{statuses: test.statuses, header: test.header}
+		transport := NewRetryTransport(stub, RetryOptions{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond})
+
+		method := test.method
+		if len(method) == 0 {
+			method = http.MethodGet
+		}
+		req, _ := http.NewRequest(method, "https://example.com/", nil)
+
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if res.StatusCode != test.status || stub.calls != test.calls {
+			t.Errorf("%s: status %d after %d calls, want %d after %d", test.name, res.StatusCode, stub.calls, test.status, test.calls)
+		}
+	}
+}
+
+func TestRetryTransportStopsWhenTheCircuitIsOpen(t *testing.T) {
+	calls := 0
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, ErrCircuitOpen
+	})
+
+	transport := NewRetryTransport(next, RetryOptions{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) || calls != 1 {
+		t.Errorf("err = %v after %d calls, want ErrCircuitOpen after 1", err, calls)
+	}
+}
+
+func TestRetryTransportStopsWhenTheCallerLeaves(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stub := &stubTransport{statuses: []int{503}}
+
+	transport := NewRetryTransport(stub, RetryOptions{MaxRetries: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/", nil)
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := transport.RoundTrip(req); !errors.Is(err, context.Canceled) || stub.calls != 1 {
+		t.Errorf("err = %v after %d calls, want context.Canceled after 1", err, stub.calls)
+	}
+}
+
+func TestCircuitBreakerTransport(t *testing.T) {
+	stub := &stubTransport{statuses: []int{500, 0, 500, 200}}
+	transport := NewCircuitBreakerTransport(stub, BreakerOptions{Threshold: 3, Cooldown: 50 * time.Millisecond})
+
+	get := func(host string) error {
+		req, _ := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		_, err := transport.RoundTrip(req)
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		get("example.com")
+	}
+
+	if err := get("example.com"); !errors.Is(err, ErrCircuitOpen) || stub.calls != 3 {
+		t.Fatalf("err = %v after %d calls, want ErrCircuitOpen after 3", err, stub.calls)
+	}
+
+	// other hosts have their own circuit
+	if err := get("example.org"); err != nil {
+		t.Errorf("example.org: %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	// a single trial request closes the circuit again
+	if err := get("example.com"); err != nil {
+		t.Errorf("trial request: %v", err)
+	}
+	if err := get("example.com"); err != nil {
+		t.Errorf("after the trial: %v", err)
+	}
+}
+
+func TestCircuitBreakerTransportLetsOneTrialThrough(t *testing.T) {
+	release := make(chan struct{})
+	calls := 0
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls > 1 {
+			<-release
+		}
+		return nil, errNetwork
+	})
+
+	transport := NewCircuitBreakerTransport(next, BreakerOptions{Threshold: 1, Cooldown: time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+
+	transport.RoundTrip(req)
+	time.Sleep(5 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		transport.RoundTrip(req)
+		close(done)
+	}()
+
+	if !waitUntil(func() bool { return trialRunning(transport, "example.com") }) {
+		t.Fatal("trial request not started")
+	}
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v while the trial runs, want ErrCircuitOpen", err)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestCircuitBreakerTransportIgnoresCanceledRequests(t *testing.T) {
+	// failures, then a canceled request, then the outcomes after it
+	var outcomes []error
+	next := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		err := outcomes[0]
+		outcomes = outcomes[1:]
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	transport := NewCircuitBreakerTransport(next, BreakerOptions{Threshold: 2, Cooldown: 20 * time.Millisecond})
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+
+	// a cancellation does not open the circuit
+	outcomes = []error{context.Canceled, context.Canceled}
+	for i := 0; i < 2; i++ {
+		if _, err := transport.RoundTrip(req); errors.Is(err, ErrCircuitOpen) {
+			t.Fatal("canceled requests opened the circuit")
+		}
+	}
+
+	// nor does it reset the failure streak
+	outcomes = []error{errNetwork, context.Canceled, errNetwork}
+	for i := 0; i < 3; i++ {
+		transport.RoundTrip(req)
+	}
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want the circuit open after two failures around a cancellation", err)
+	}
+
+	// nor does a canceled trial close the circuit, the next request after the cooldown is a trial again
+	time.Sleep(30 * time.Millisecond)
+	outcomes = []error{context.Canceled, errNetwork}
+	transport.RoundTrip(req)
+	if _, err := transport.RoundTrip(req); !errors.Is(err, errNetwork) {
+		t.Fatalf("err = %v, want a second trial after the canceled one", err)
+	}
+	if _, err := transport.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v, want the circuit open again after the failed trial", err)
+	}
+}
+
+func trialRunning(t CircuitBreakerTransport, host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.circuits[host]
+
+	return ok && c.trial
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFallbackProviderForgetsEntriesNoLongerListed(t *testing.T) {
+	failing := false
+	fallback := NewFallbackProvider(providerFunc(func(ctx context.Context, entry PackageListEntry) ([]Version, error) {
+		if failing {
+			return nil, errNetwork
+		}
+		return []Version{{Version: "1.0.0"}}, nil
+	}))
+
+	listed := PackageListEntry{Id: "Listed.Package"}
+	edited := PackageListEntry{Id: "Edited.Package", MaxReleases: 1}
+	for _, entry := range []PackageListEntry{listed, edited} {
+		if _, err := fallback.FetchVersions(context.Background(), entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repository := NewWingetSrcRepository([]PackageListEntry{listed, edited}, NewCoalescingProvider(fallback), NewManifestIndex(), 1)
+	repository.SetPackageList([]PackageListEntry{listed, {Id: "Edited.Package", MaxReleases: 2}})
+
+	failing = true
+
+	if _, err := fallback.FetchVersions(context.Background(), listed); err != nil {
+		t.Errorf("listed entry: %v, want its last known versions", err)
+	}
+	if _, err := fallback.FetchVersions(context.Background(), edited); err == nil {
+		t.Error("versions of the entry before its edit are still served")
+	}
+}
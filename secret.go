@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretAgePrefix  = "age:"
+	ageArmorHeader   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageHeaderVersion = "age-encryption.org/v1"
+
+	// redactedSecret replaces secrets in logs and error messages.
+	redactedSecret = "[REDACTED]"
+	// minRedactedLength keeps short values such as "1" from masking unrelated text.
+	minRedactedLength = 6
+)
+
+// isEncrypted reports whether value is age encrypted, either as age:BASE64 or as an armored block.
+func isEncrypted(value string) bool {
+	return strings.HasPrefix(value, secretAgePrefix) || strings.HasPrefix(strings.TrimSpace(value), ageArmorHeader)
+}
+
+// isSecretReference reports whether value refers to a secret, or encrypts it, instead of holding it.
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretEnvPrefix) || strings.HasPrefix(value, secretFilePrefix) || isEncrypted(value)
+}
+
+// ageCiphertext returns the age file held by an encrypted value.
+func ageCiphertext(value string) (io.Reader, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), ageArmorHeader) {
+		return armor.NewReader(strings.NewReader(strings.TrimSpace(value))), nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretAgePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted value is not base64: %w", err)
+	}
+
+	return bytes.NewReader(raw), nil
+}
+
+// checkEncrypted verifies that an encrypted value is an age file without decrypting it.
+func checkEncrypted(value string) error {
+	ciphertext, err := ageCiphertext(value)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(ageHeaderVersion))
+	if _, err := io.ReadFull(ciphertext, header); err != nil || string(header) != ageHeaderVersion {
+		return errors.New("encrypted value is not an age file")
+	}
+
+	return nil
+}
+
+// encryptSecret encrypts value to the recipients as age:BASE64.
+func encryptSecret(value string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return secretAgePrefix + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// LoadAgeIdentities reads the age identities of a key file as written by age-keygen.
+func LoadAgeIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return identities, nil
+}
+
+// SecretResolver resolves the secret references of the package list.
+type SecretResolver struct {
+	// Identities decrypt encrypted values; they are only needed when the list has some.
+	Identities []age.Identity
+	// RemoteEnv are the only env vars a remote package list may reference.
+	RemoteEnv []string
+}
+
+// Resolve returns the secret referenced by env:NAME or file:PATH, or encrypted by age, or value itself when
+// it is not a reference. Relative paths are relative to dir. Resolved secrets are registered to the redactor.
+// A value of a remote package list comes with root, the directory of its local copy: it may only reference
+// the env vars of RemoteEnv and the files below root, so that the list cannot send other secrets anywhere.
+func (r *SecretResolver) Resolve(value string, dir string, root string) (string, error) {
+	var secret string
+
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		if len(root) != 0 && !r.allowsRemoteEnv(name) {
+			return "", fmt.Errorf("env var %s may not be referenced by a remote package list, allow it with secrets.remote_env", name)
+		}
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("env var %s is not set", name)
+		}
+		secret = env
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := strings.TrimPrefix(value, secretFilePrefix)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if len(root) != 0 && !withinRoot(root, path) {
+			return "", fmt.Errorf("file %s is missing or outside of the remote package list", path)
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		// secret files usually end with a newline
+		secret = strings.TrimSpace(string(contents))
+	case isEncrypted(value):
+		plaintext, err := r.decrypt(value)
+		if err != nil {
+			return "", err
+		}
+		secret = plaintext
+	default:
+		secret = value
+	}
+
+	redactor.Add(secret)
+
+	return secret, nil
+}
+
+func (r *SecretResolver) allowsRemoteEnv(name string) bool {
+	if r == nil {
+		return false
+	}
+
+	for _, allowed := range r.RemoteEnv {
+		if allowed == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *SecretResolver) decrypt(value string) (string, error) {
+	if r == nil || len(r.Identities) == 0 {
+		return "", errors.New("value is encrypted but no age identity is configured")
+	}
+
+	ciphertext, err := ageCiphertext(value)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := age.Decrypt(ciphertext, r.Identities...)
+	if err != nil {
+		return "", err
+	}
+
+	contents, err := io.ReadAll(plaintext)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// resolveSecrets resolves the secret references of the profiles and entries in place: tokens, and the
+// header values of profiles.
+// File references are relative to the file setting them. root is the local copy of a remote package list,
+// confining the references set by its files but not by the local overlays.
+func resolveSecrets(f *parsedPackageList, resolver *SecretResolver, root string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+
+	resolve := func(value string, ref nodeRef) (string, error) {
+		confined := ""
+		if len(root) != 0 && withinRoot(root, ref.path) {
+			confined = root
+		}
+
+		return resolver.Resolve(value, filepath.Dir(ref.path), confined)
+	}
+
+	// entries inheriting the token of their profile reuse its resolution instead of reporting it again
+	inherited := map[string][2]string{}
+
+	for _, name := range providerProfileNames(f.providers) {
+		profile := f.providers[name]
+
+		token, err := resolve(profile.Token, sourceOf(f.providerRefs[name], "token"))
+		inherited[name] = [2]string{profile.Token, token}
+		if err != nil {
+			diagnostics = append(diagnostics, f.providerAt(name, "token", SeverityError, "token: %s", err))
+		}
+		profile.Token = token
+
+		for header, value := range profile.HTTP.Headers {
+			resolved, err := resolve(value, sourceOf(f.providerRefs[name], "http"))
+			if err != nil {
+				diagnostics = append(diagnostics, f.providerAt(name, "http", SeverityError, "header %s: %s", header, err))
+			}
+			profile.HTTP.Headers[header] = resolved
+		}
+
+		f.providers[name] = profile
+	}
+
+	for i := range f.entries {
+		if profile, ok := inherited[f.entries[i].Provider]; ok && profile[0] == f.entries[i].Token {
+			f.entries[i].Token = profile[1]
+			continue
+		}
+
+		token, err := resolve(f.entries[i].Token, sourceOf(f.entryRefs[i], "token"))
+		if err != nil {
+			diagnostics = append(diagnostics, f.at(i, "token", SeverityError, "token: %s", err))
+		}
+		f.entries[i].Token = token
+	}
+
+	return diagnostics
+}
+
+// Redactor masks known secrets in text.
+type Redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// redactor masks every secret known to the process in logs and error messages.
+var redactor = &Redactor{}
+
+// Add registers secrets to mask.
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.secrets == nil {
+		r.secrets = map[string]bool{}
+	}
+
+	added := false
+	for _, secret := range secrets {
+		if len(secret) >= minRedactedLength && !r.secrets[secret] {
+			r.secrets[secret] = true
+			added = true
+		}
+	}
+
+	if !added {
+		return
+	}
+
+	// longer secrets go first so that a secret containing another one is masked as a whole
+	secrets = []string{}
+	for secret := range r.secrets {
+		secrets = append(secrets, secret)
+	}
+	sort.Slice(secrets, func(a, b int) bool {
+		return len(secrets[a]) > len(secrets[b])
+	})
+
+	pairs := []string{}
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, redactedSecret)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with every registered secret masked.
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.replacer == nil {
+		return s
+	}
+
+	return r.replacer.Replace(s)
+}
+
+// RedactingHandler is the slog.Handler masking secrets in messages, string attributes and errors.
+type RedactingHandler struct {
+	next     slog.Handler
+	redactor *Redactor
+}
+
+func NewRedactingHandler(next slog.Handler, redactor *Redactor) *RedactingHandler {
+	return &RedactingHandler{
+		next:     next,
+		redactor: redactor,
+	}
+}
+
+func (h *RedactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.redactor.Redact(value.String()))
+	case slog.KindGroup:
+		attrs := []any{}
+		for _, a := range value.Group() {
+			attrs = append(attrs, h.redactAttr(a))
+		}
+		return slog.Group(attr.Key, attrs...)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(attr.Key, h.redactor.Redact(err.Error()))
+		}
+	}
+
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// Enabled implements slog.Handler.
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *RedactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+// WithAttrs implements slog.Handler.
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := []slog.Attr{}
+	for _, attr := range attrs {
+		redacted = append(redacted, h.redactAttr(attr))
+	}
+
+	return NewRedactingHandler(h.next.WithAttrs(redacted), h.redactor)
+}
+
+// WithGroup implements slog.Handler.
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return NewRedactingHandler(h.next.WithGroup(name), h.redactor)
+}
+
+var _ slog.Handler = &RedactingHandler{}
+
+// upstreamErrorExcerptLength bounds how much of an upstream error body ends up in error messages.
+const upstreamErrorExcerptLength = 256
+
+// upstreamStatusError describes an unexpected upstream response with a short redacted excerpt of its body,
+// since upstream bodies may echo request details and end up in client responses.
+func upstreamStatusError(what string, res *http.Response) error {
+	contents, _ := io.ReadAll(io.LimitReader(res.Body, upstreamErrorExcerptLength))
+
+	excerpt := strings.ToValidUTF8(strings.Join(strings.Fields(string(contents)), " "), "")
+
+	return fmt.Errorf("%s status %d: %s", what, res.StatusCode, redactor.Redact(excerpt))
+}
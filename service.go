@@ -1,33 +1,43 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
 
 type WingetSrcService interface {
 	Information() (InformationResponse, error)
-	ManifestSearch(req ManifestSearchRequest) (ManifestSearchResponse, error)
-	PackageManifests(identifier string, version string) (PackageManifestsResponse, error)
+	ManifestSearch(ctx context.Context, req ManifestSearchRequest) (ManifestSearchResponse, error)
+	PackageManifests(ctx context.Context, identifier string, version string) (PackageManifestsResponse, error)
+	RefreshPackages(ctx context.Context, condition QueryManifestConditon) []string
+}
+
+type ServiceOptions struct {
+	// Information is served as is by /information.
+	Information InformationResponse
+	// Strict makes a search fail as a whole when any package fails, otherwise the resolvable packages are
+	// returned together with a *PartialResultError.
+	Strict bool
 }
 
 type WingetSrcServiceImpl struct {
 	repository WingetSrcRepository
+	options    ServiceOptions
 }
 
-func NewWingetSrcService(repository WingetSrcRepository) WingetSrcService {
+func NewWingetSrcService(repository WingetSrcRepository, options ServiceOptions) WingetSrcService {
 	return WingetSrcServiceImpl{
 		repository: repository,
+		options:    options,
 	}
 }
 
 func (w WingetSrcServiceImpl) Information() (InformationResponse, error) {
-	return InformationResponse{
-		SourceIdentifier: "api.winget-src",
-		ServerSupportedVersions: []string{
-			"1.4.0",
-			"1.5.0",
-		},
-	}, nil
+	return w.options.Information, nil
 }
-func (w WingetSrcServiceImpl) ManifestSearch(req ManifestSearchRequest) (ManifestSearchResponse, error) {
+func (w WingetSrcServiceImpl) ManifestSearch(ctx context.Context, req ManifestSearchRequest) (ManifestSearchResponse, error) {
 	conditons := []QueryManifestConditon{}
 
 	if req.Query.Keyword != "" {
@@ -64,7 +74,22 @@ func (w WingetSrcServiceImpl) ManifestSearch(req ManifestSearchRequest) (Manifes
 		conditons = append(conditons, And(andConds...))
 	}
 
-	maniests, err := w.repository.QueryManifest(And(conditons...))
+	maniests, err := w.repository.QueryManifest(ctx, And(conditons...))
+
+	var partial *PartialResultError
+	if errors.As(err, &partial) {
+		if w.options.Strict {
+			// not wrapped, so that the search fails as a whole instead of being served as a partial result
+			return ManifestSearchResponse{}, fmt.Errorf("strict search: %s", partial)
+		}
+
+		for _, failure := range partial.Failures {
+			slog.Warn("package omitted from search result", "id", failure.Id, "error", failure.Err)
+		}
+
+		return maniests, partial
+	}
+
 	if err != nil {
 		return ManifestSearchResponse{}, err
 	}
@@ -72,8 +97,8 @@ func (w WingetSrcServiceImpl) ManifestSearch(req ManifestSearchRequest) (Manifes
 	return maniests, nil
 }
 
-func (w WingetSrcServiceImpl) PackageManifests(identifier string, version string) (PackageManifestsResponse, error) {
-	res, err := w.repository.QueryPackageManifests(identifier)
+func (w WingetSrcServiceImpl) PackageManifests(ctx context.Context, identifier string, version string) (PackageManifestsResponse, error) {
+	res, err := w.repository.QueryPackageManifests(ctx, identifier)
 	if err != nil {
 		return PackageManifestsResponse{}, err
 	}
@@ -96,3 +121,33 @@ func (w WingetSrcServiceImpl) PackageManifests(identifier string, version string
 
 	return PackageManifestsResponse(res), nil
 }
+
+// RefreshPackages invalidates and refreshes the packages matching condition in the background,
+// returning their identifiers right away.
+func (w WingetSrcServiceImpl) RefreshPackages(ctx context.Context, condition QueryManifestConditon) []string {
+	matched := []PackageListEntry{}
+	ids := []string{}
+
+	for _, entry := range w.repository.PackageList() {
+		if condition(entry) {
+			matched = append(matched, entry)
+			ids = append(ids, entry.Id)
+		}
+	}
+
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		for _, entry := range matched {
+			diff, err := w.repository.Invalidate(ctx, entry)
+			if err != nil {
+				slog.Warn("refresh failed", "id", entry.Id, "error", err)
+				continue
+			}
+
+			slog.Info("refreshed", "id", entry.Id, "added", diff.Added, "removed", diff.Removed)
+		}
+	}()
+
+	return ids
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Snapshot struct {
+	SavedAt  time.Time
+	Packages []PackageManifests
+	// RefreshedAt records when each package was last refreshed from its upstream, which may be long before SavedAt.
+	RefreshedAt map[string]time.Time
+}
+
+type SnapshotStatus struct {
+	Path     string
+	SavedAt  time.Time
+	LoadedAt time.Time
+	// OldestRefreshAt is when the least recently refreshed package was last resolved from its upstream.
+	OldestRefreshAt time.Time
+	// AgeSeconds is the age of OldestRefreshAt, that is of the stalest manifests served.
+	AgeSeconds int64
+	// Restored lists the packages still served from the snapshot loaded at startup.
+	Restored []string
+}
+
+// Snapshotter periodically persists the manifest index to a file and restores it at startup,
+// so that the source keeps serving the last known manifests while upstreams are unreachable.
+type Snapshotter struct {
+	path  string
+	index *ManifestIndex
+
+	mu       sync.Mutex
+	savedAt  time.Time
+	loadedAt time.Time
+}
+
+func NewSnapshotter(path string, index *ManifestIndex) *Snapshotter {
+	return &Snapshotter{
+		path:  path,
+		index: index,
+	}
+}
+
+// Load restores the snapshot into the index. A missing snapshot file is not an error.
+func (s *Snapshotter) Load() error {
+	contents, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(contents, &snapshot); err != nil {
+		return err
+	}
+
+	// snapshots written before refresh times were recorded are only known to be as fresh as their write
+	refreshedAt := map[string]time.Time{}
+	for _, pkgManifests := range snapshot.Packages {
+		refreshedAt[pkgManifests.PackageIdentifier] = snapshot.SavedAt
+		if at, ok := snapshot.RefreshedAt[pkgManifests.PackageIdentifier]; ok {
+			refreshedAt[pkgManifests.PackageIdentifier] = at
+		}
+	}
+
+	s.index.Restore(snapshot.Packages, refreshedAt)
+
+	s.mu.Lock()
+	s.savedAt = snapshot.SavedAt
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	slog.Info("snapshot loaded", "path", s.path, "packages", len(snapshot.Packages), "age", time.Since(snapshot.SavedAt).Round(time.Second))
+
+	return nil
+}
+
+// Save writes the index to the snapshot file atomically.
+func (s *Snapshotter) Save() error {
+	snapshot := Snapshot{
+		SavedAt:     time.Now(),
+		Packages:    s.index.All(),
+		RefreshedAt: s.index.RefreshedAt(),
+	}
+
+	contents, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.savedAt = snapshot.SavedAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Run saves the snapshot every interval and a last time when ctx is done.
+func (s *Snapshotter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Save(); err != nil {
+				slog.Error("snapshot save failed", "path", s.path, "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				slog.Error("snapshot save failed", "path", s.path, "error", err)
+			}
+		}
+	}
+}
+
+func (s *Snapshotter) Status() SnapshotStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := SnapshotStatus{
+		Path:     s.path,
+		SavedAt:  s.savedAt,
+		LoadedAt: s.loadedAt,
+		Restored: s.index.Restored(),
+	}
+
+	for _, at := range s.index.RefreshedAt() {
+		if status.OldestRefreshAt.IsZero() || at.Before(status.OldestRefreshAt) {
+			status.OldestRefreshAt = at
+		}
+	}
+
+	if !status.OldestRefreshAt.IsZero() {
+		status.AgeSeconds = int64(time.Since(status.OldestRefreshAt).Seconds())
+	}
+
+	return status
+}
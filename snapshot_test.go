@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotKeepsRefreshTimes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	refreshedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	index := NewManifestIndex()
+	index.Restore([]PackageManifests{{PackageIdentifier: "Stale.Package"}}, map[string]time.Time{"Stale.Package": refreshedAt})
+	index.Put(PackageManifests{PackageIdentifier: "Fresh.Package"})
+
+	// saving again must not make the stale package look fresh
+	snapshotter := NewSnapshotter(path, index)
+	if err := snapshotter.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	status := snapshotter.Status()
+	if !status.OldestRefreshAt.Equal(refreshedAt) {
+		t.Errorf("OldestRefreshAt = %v, want %v", status.OldestRefreshAt, refreshedAt)
+	}
+	if status.AgeSeconds < 3600 {
+		t.Errorf("AgeSeconds = %d, want at least 3600", status.AgeSeconds)
+	}
+
+	restored := NewManifestIndex()
+	if err := NewSnapshotter(path, restored).Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := restored.RefreshedAt()["Stale.Package"]; !got.Equal(refreshedAt) {
+		t.Errorf("restored refresh time = %v, want %v", got, refreshedAt)
+	}
+	if got := restored.Restored(); len(got) != 2 {
+		t.Errorf("Restored() = %v, want both packages", got)
+	}
+}
+
+func TestSnapshotDropsPackagesNoLongerListed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	index := NewManifestIndex()
+	index.Restore([]PackageManifests{{PackageIdentifier: "Removed.Package"}}, map[string]time.Time{"Removed.Package": time.Now().Add(-24 * time.Hour)})
+	index.Put(PackageManifests{PackageIdentifier: "Listed.Package"})
+	if err := NewSnapshotter(path, index).Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewManifestIndex()
+	snapshotter := NewSnapshotter(path, restored)
+	if err := snapshotter.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	NewWingetSrcRepository([]PackageListEntry{{Id: "Listed.Package"}}, nil, restored, 1)
+
+	if _, ok := restored.Get("Removed.Package"); ok {
+		t.Error("package removed from the list is still indexed")
+	}
+
+	status := snapshotter.Status()
+	if len(status.Restored) != 1 || status.Restored[0] != "Listed.Package" {
+		t.Errorf("Restored = %v, want [Listed.Package]", status.Restored)
+	}
+	if status.AgeSeconds > 60 {
+		t.Errorf("AgeSeconds = %d, still pinned by the removed package", status.AgeSeconds)
+	}
+
+	// nor is it written back
+	if err := snapshotter.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved := NewManifestIndex()
+	if err := NewSnapshotter(path, saved).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := saved.Get("Removed.Package"); ok {
+		t.Error("package removed from the list is still saved")
+	}
+}
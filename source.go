@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitSourcePrefix marks a package list living in a git repository, as in
+// git+https://example.com/config.git#main:winget/packages.yaml, where the branch and the path in the
+// repository are both optional.
+const gitSourcePrefix = "git+"
+
+// PackageListSource brings the package list to a local file or directory it can be loaded from.
+type PackageListSource interface {
+	// Fetch updates the local copy of the package list and returns its path.
+	Fetch(ctx context.Context) (string, error)
+	// Close removes the local copy.
+	Close() error
+	// Root returns the directory of the local copy of a remote package list, which the list may not reference
+	// files outside of. It is empty for a local package list.
+	Root() string
+}
+
+// NewPackageListSource returns the source of the package list at location: a local path, an http(s) URL
+// or a git repository. Remote sources are fetched with the timeout.
+func NewPackageListSource(location string, timeout time.Duration) (PackageListSource, error) {
+	switch {
+	case strings.HasPrefix(location, gitSourcePrefix):
+		return newGitSource(strings.TrimPrefix(location, gitSourcePrefix), timeout)
+	case isHTTPURL(location):
+		return newHTTPSource(location, timeout)
+	default:
+		return localSource(location), nil
+	}
+}
+
+// isRemoteSource reports whether the package list at location is fetched over http or git rather than read
+// from the local filesystem.
+func isRemoteSource(location string) bool {
+	return strings.HasPrefix(location, gitSourcePrefix) || isHTTPURL(location)
+}
+
+// sourceURLPassword returns the password of a remote source location, so that it can be redacted.
+func sourceURLPassword(location string) string {
+	u, err := url.Parse(strings.TrimPrefix(location, gitSourcePrefix))
+	if err != nil {
+		return ""
+	}
+
+	password, _ := u.User.Password()
+
+	return password
+}
+
+// redactSourceURL masks the password of a remote source location.
+func redactSourceURL(location string) string {
+	u, err := url.Parse(strings.TrimPrefix(location, gitSourcePrefix))
+	if err != nil || u.User == nil {
+		return location
+	}
+
+	if strings.HasPrefix(location, gitSourcePrefix) {
+		return gitSourcePrefix + u.Redacted()
+	}
+
+	return u.Redacted()
+}
+
+type localSource string
+
+// Fetch implements PackageListSource.
+func (s localSource) Fetch(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// Close implements PackageListSource.
+func (s localSource) Close() error {
+	return nil
+}
+
+// Root implements PackageListSource.
+func (s localSource) Root() string {
+	return ""
+}
+
+// httpSource downloads the package list with conditional requests, so that polling an unchanged list only
+// costs a 304.
+type httpSource struct {
+	url    string
+	client *http.Client
+	dir    string
+	path   string
+
+	etag         string
+	lastModified string
+}
+
+func newHTTPSource(location string, timeout time.Duration) (*httpSource, error) {
+	dir, err := os.MkdirTemp("", "winget-src-package-list-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpSource{
+		url:    location,
+		client: &http.Client{Timeout: timeout},
+		dir:    dir,
+	}, nil
+}
+
+// fileName returns the name the downloaded list is saved as, keeping an extension so that its format is
+// detected, taken from the URL or else from the content type.
+func (s *httpSource) fileName(u *url.URL, contentType string) string {
+	name := path.Base(u.Path)
+	if _, ok := packageListExtensions[strings.ToLower(path.Ext(name))]; ok {
+		return name
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return "packages.json"
+	case strings.HasSuffix(mediaType, "toml"):
+		return "packages.toml"
+	case strings.HasSuffix(mediaType, "yaml"):
+		return "packages.yaml"
+	}
+
+	// the format is detected from the content
+	return "packages"
+}
+
+// Fetch implements PackageListSource.
+func (s *httpSource) Fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if len(s.path) != 0 {
+		if len(s.etag) != 0 {
+			req.Header.Set("If-None-Match", s.etag)
+		}
+		if len(s.lastModified) != 0 {
+			req.Header.Set("If-Modified-Since", s.lastModified)
+		}
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("package list download: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && len(s.path) != 0 {
+		return s.path, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", upstreamStatusError("package list download", res)
+	}
+
+	// the list is written aside then renamed, so that a failed download leaves the previous one intact
+	tmp, err := os.CreateTemp(s.dir, ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, res.Body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("package list download: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	listPath := filepath.Join(s.dir, s.fileName(res.Request.URL, res.Header.Get("Content-Type")))
+	if err := os.Rename(tmp.Name(), listPath); err != nil {
+		return "", err
+	}
+
+	s.path = listPath
+	s.etag = res.Header.Get("ETag")
+	s.lastModified = res.Header.Get("Last-Modified")
+
+	return s.path, nil
+}
+
+// Close implements PackageListSource.
+func (s *httpSource) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Root implements PackageListSource.
+func (s *httpSource) Root() string {
+	return s.dir
+}
+
+// gitSource keeps a shallow clone of a branch of a repository, local or remote, and returns the path of the
+// package list in it. Credentials are those of the git configuration, or of the repository URL.
+type gitSource struct {
+	repository string
+	branch     string
+	path       string
+	timeout    time.Duration
+	dir        string
+	cloned     bool
+}
+
+func newGitSource(location string, timeout time.Duration) (*gitSource, error) {
+	repository, fragment, _ := strings.Cut(location, "#")
+	// git forbids colons in branch names, so the first one separates the path
+	branch, listPath, _ := strings.Cut(fragment, ":")
+
+	if len(repository) == 0 {
+		return nil, errors.New("git package list needs a repository")
+	}
+
+	if filepath.IsAbs(listPath) || strings.HasPrefix(filepath.Clean(listPath), "..") {
+		return nil, fmt.Errorf("git package list path %q must be relative to the repository root", listPath)
+	}
+
+	dir, err := os.MkdirTemp("", "winget-src-package-list-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitSource{
+		repository: repository,
+		branch:     branch,
+		path:       listPath,
+		timeout:    timeout,
+		dir:        dir,
+	}, nil
+}
+
+// git runs a git command in dir, or in the working directory when dir is empty.
+func (s *gitSource) git(ctx context.Context, dir string, args ...string) error {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// a missing credential fails the fetch instead of waiting for a prompt
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// Fetch implements PackageListSource.
+func (s *gitSource) Fetch(ctx context.Context) (string, error) {
+	checkout := filepath.Join(s.dir, "repository")
+
+	if !s.cloned {
+		args := []string{"clone", "--quiet", "--depth", "1", "--single-branch"}
+		if len(s.branch) != 0 {
+			args = append(args, "--branch", s.branch)
+		}
+		if err := s.git(ctx, "", append(args, "--", s.repository, checkout)...); err != nil {
+			os.RemoveAll(checkout)
+			return "", err
+		}
+		s.cloned = true
+	} else {
+		ref := s.branch
+		if len(ref) == 0 {
+			ref = "HEAD"
+		}
+		if err := s.git(ctx, checkout, "fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+			return "", err
+		}
+		if err := s.git(ctx, checkout, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(checkout, s.path), nil
+}
+
+// Close implements PackageListSource.
+func (s *gitSource) Close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// Root implements PackageListSource.
+func (s *gitSource) Root() string {
+	return filepath.Join(s.dir, "repository")
+}
+
+// withinRoot reports whether path, once its symbolic links are followed, is root or below it.
+func withinRoot(root string, path string) bool {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return false
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(resolvedRoot, resolved)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+var (
+	_ PackageListSource = localSource("")
+	_ PackageListSource = &httpSource{}
+	_ PackageListSource = &gitSource{}
+)
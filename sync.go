@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// syncPollInterval bounds how long the scheduler sleeps, so entries added by a reload are picked up.
+const syncPollInterval = time.Minute
+
+// Syncer refreshes every package list entry in the background, each on its own interval.
+type Syncer struct {
+	repository WingetSrcRepository
+	interval   time.Duration
+	jitter     float64
+	workers    int
+
+	ready atomic.Bool
+}
+
+// NewSyncer creates a Syncer refreshing entries every interval, unless they override it, randomly
+// spread by up to jitter (a fraction of the interval) so that entries do not stay synchronized.
+func NewSyncer(repository WingetSrcRepository, interval time.Duration, jitter float64, workers int) *Syncer {
+	return &Syncer{
+		repository: repository,
+		interval:   interval,
+		jitter:     jitter,
+		workers:    workers,
+	}
+}
+
+// Ready reports whether the warm-up sync of all entries has completed.
+func (s *Syncer) Ready() bool {
+	return s.ready.Load()
+}
+
+func (s *Syncer) delay(entry PackageListEntry) time.Duration {
+	interval := s.interval
+	if entry.SyncInterval > 0 {
+		interval = entry.SyncInterval
+	}
+
+	spread := time.Duration(float64(interval) * s.jitter)
+	if spread <= 0 {
+		return interval
+	}
+
+	return interval - spread + time.Duration(rand.Int63n(int64(2*spread)))
+}
+
+// Run warms up every entry, marks the syncer ready and then refreshes entries as they become due until ctx is done.
+func (s *Syncer) Run(ctx context.Context) {
+	s.sync(ctx, s.repository.PackageList())
+	s.ready.Store(true)
+
+	next := map[string]time.Time{}
+	for _, entry := range s.repository.PackageList() {
+		next[entry.Id] = time.Now().Add(s.delay(entry))
+	}
+
+	for {
+		now := time.Now()
+		wait := syncPollInterval
+		due := []PackageListEntry{}
+		listed := map[string]bool{}
+
+		for _, entry := range s.repository.PackageList() {
+			listed[entry.Id] = true
+
+			at, ok := next[entry.Id]
+			if !ok || !at.After(now) {
+				due = append(due, entry)
+				continue
+			}
+
+			if at.Sub(now) < wait {
+				wait = at.Sub(now)
+			}
+		}
+
+		for id := range next {
+			if !listed[id] {
+				delete(next, id)
+			}
+		}
+
+		if len(due) != 0 {
+			s.sync(ctx, due)
+			for _, entry := range due {
+				next[entry.Id] = time.Now().Add(s.delay(entry))
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// sync refreshes the entries concurrently, keeping the previous manifests of entries that fail.
+func (s *Syncer) sync(ctx context.Context, entries []PackageListEntry) {
+	start := time.Now()
+	diffs := make([]VersionDiff, len(entries))
+	errs := make([]error, len(entries))
+
+	parallel(len(entries), s.workers, func(i int) {
+		diffs[i], errs[i] = s.repository.Refresh(ctx, entries[i])
+	})
+
+	failed := 0
+	for i, entry := range entries {
+		if errs[i] != nil {
+			failed++
+			slog.Warn("sync failed", "id", entry.Id, "error", errs[i])
+			continue
+		}
+
+		if !diffs[i].Empty() {
+			slog.Info("sync changed versions", "id", entry.Id, "added", diffs[i].Added, "removed", diffs[i].Removed)
+		}
+	}
+
+	slog.Info("sync done", "entries", len(entries), "failed", failed, "elapsed", time.Since(start))
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"context"
+	"time"
+)
+
 type PackageListEntry struct {
 	Provider      string `yaml:"provider"`
 	Id            string `yaml:"id"`
@@ -7,9 +12,16 @@ type PackageListEntry struct {
 	Publisher     string `yaml:"publisher"`
 	Description   string `yaml:"description"`
 	Endpoint      string `yaml:"endpoint"`
+	Owner         string `yaml:"owner"`
+	Repo          string `yaml:"repo"`
 	ProjectID     uint   `yaml:"project_id"`
 	Token         string `yaml:"token"`
 	InstallerType string `yaml:"installer_type"`
+	MaxReleases   int    `yaml:"max_releases"`
+	// SyncInterval overrides how often the entry is refreshed in the background.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	// Type is the type of the provider profile named by Provider, resolved when the package list is loaded.
+	Type string `yaml:"-"`
 }
 
 type Version struct {
@@ -18,5 +30,18 @@ type Version struct {
 }
 
 type PackageProvider interface {
-	FetchVersions(PackageListEntry) ([]Version, error)
+	FetchVersions(context.Context, PackageListEntry) ([]Version, error)
+}
+
+// entryRetainer is implemented by providers keeping state per entry, which they drop for the entries
+// missing from packageList.
+type entryRetainer interface {
+	Retain(packageList []PackageListEntry)
+}
+
+// retainEntries lets provider drop the state of the entries missing from packageList, if it keeps any.
+func retainEntries(provider PackageProvider, packageList []PackageListEntry) {
+	if retainer, ok := provider.(entryRetainer); ok {
+		retainer.Retain(packageList)
+	}
 }
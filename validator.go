@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+type validated struct {
+	etag         string
+	lastModified string
+	value        any
+	// owner is the cacheKey of the entry the value was fetched for.
+	owner string
+}
+
+// ValidatorCache keeps the ETag and Last-Modified validators of upstream responses together with the
+// value parsed from them, so that a 304 Not Modified answer can reuse the previous result.
+type ValidatorCache struct {
+	mu      sync.Mutex
+	entries map[string]validated
+}
+
+func NewValidatorCache() *ValidatorCache {
+	return &ValidatorCache{
+		entries: map[string]validated{},
+	}
+}
+
+// Apply turns req into a conditional request if validators are stored for its URL.
+func (c *ValidatorCache) Apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.entries[req.URL.String()]
+	if !ok {
+		return
+	}
+
+	if len(v.etag) != 0 {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+
+	if len(v.lastModified) != 0 {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+}
+
+// Lookup returns the value stored for key.
+func (c *ValidatorCache) Lookup(key string) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v, ok := c.entries[key]
+
+	return v.value, ok
+}
+
+// Store remembers value parsed from a response to the URL key fetched for entry, if the response carries
+// any validator.
+func (c *ValidatorCache) Store(entry PackageListEntry, key string, header http.Header, value any) {
+	if c == nil {
+		return
+	}
+
+	v := validated{
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		value:        value,
+		owner:        cacheKey(entry),
+	}
+
+	if len(v.etag) == 0 && len(v.lastModified) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = v
+}
+
+// Remember stores a value derived from conditional responses for entry, such as the versions built from
+// unchanged release pages.
+func (c *ValidatorCache) Remember(entry PackageListEntry, key string, value any) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = validated{value: value, owner: cacheKey(entry)}
+}
+
+// Retain drops the values fetched for entries that are not listed anymore, edited ones included. A value
+// shared with a listed entry is dropped too when another entry stored it last, and is simply fetched again.
+func (c *ValidatorCache) Retain(packageList []PackageListEntry) {
+	if c == nil {
+		return
+	}
+
+	listed := map[string]bool{}
+	for _, entry := range packageList {
+		listed[cacheKey(entry)] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, v := range c.entries {
+		if !listed[v.owner] {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxWebhookBody bounds the request bodies read by the webhook receivers; release events are far smaller.
+const maxWebhookBody = 1 << 20
+
+type WebhookOptions struct {
+	// GithubSecret verifies X-Hub-Signature-256 of GitHub webhooks; the endpoint is disabled when empty.
+	GithubSecret string
+	// GitlabToken is compared to X-Gitlab-Token of GitLab webhooks; the endpoint is disabled when empty.
+	GitlabToken string
+}
+
+type githubReleaseEvent struct {
+	Repository struct {
+		Url string `json:"url"`
+	} `json:"repository"`
+}
+
+type gitlabReleaseEvent struct {
+	Project struct {
+		Id     uint   `json:"id"`
+		WebUrl string `json:"web_url"`
+	} `json:"project"`
+}
+
+type WebhookResponse struct {
+	Refreshed []string
+}
+
+func verifyGithubSignature(secret string, body []byte, signature string) bool {
+	digest, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		{
+			ErrorCode:    status,
+			ErrorMessage: message,
+		},
+	})
+}
+
+// writeBodyError reports a webhook body that could not be read or decoded, telling oversized ones apart.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+func writeRefreshed(w http.ResponseWriter, ids []string) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(DataResponse{
+		Data: WebhookResponse{
+			Refreshed: ids,
+		},
+	})
+}
+
+// mountWebhooks registers the release webhook receivers that refresh the matching packages immediately.
+func mountWebhooks(r chi.Router, service WingetSrcService, options WebhookOptions) {
+	if len(options.GithubSecret) != 0 {
+		r.Post("/webhooks/github", func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			if !verifyGithubSignature(options.GithubSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+				writeError(w, http.StatusUnauthorized, "invalid signature")
+				return
+			}
+
+			switch r.Header.Get("X-GitHub-Event") {
+			case "ping":
+				w.WriteHeader(http.StatusNoContent)
+				return
+			case "release":
+			default:
+				writeError(w, http.StatusBadRequest, "unsupported event")
+				return
+			}
+
+			var event githubReleaseEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			writeRefreshed(w, service.RefreshPackages(r.Context(), ByGithubRepository(event.Repository.Url)))
+		})
+	}
+
+	if len(options.GitlabToken) != 0 {
+		r.Post("/webhooks/gitlab", func(w http.ResponseWriter, r *http.Request) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(options.GitlabToken)) != 1 {
+				writeError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+
+			if r.Header.Get("X-Gitlab-Event") != "Release Hook" {
+				writeError(w, http.StatusBadRequest, "unsupported event")
+				return
+			}
+
+			var event gitlabReleaseEvent
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&event); err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			writeRefreshed(w, service.RefreshPackages(r.Context(), ByGitlabProject(event.Project.WebUrl, event.Project.Id)))
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestWebhooksRejectOversizedBodies(t *testing.T) {
+	r := chi.NewRouter()
+	mountWebhooks(r, nil, WebhookOptions{GithubSecret: "secret", GitlabToken: "token"})
+
+	body := bytes.Repeat([]byte(" "), maxWebhookBody+1)
+
+	tests := map[string]http.Header{
+		"/webhooks/github": {"X-Hub-Signature-256": {"sha256=00"}},
+		"/webhooks/gitlab": {"X-Gitlab-Token": {"token"}, "X-Gitlab-Event": {"Release Hook"}},
+	}
+
+	for path, header := range tests {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header = header
+		rec := httptest.NewRecorder()
+
+		r.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}